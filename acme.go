@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// acmeKeyFile is the name of the ACME account key file in the certificate
+// cache directory.
+const acmeKeyFile = "acme_account.key"
+
+// PersistentACMEClient returns an ACME client whose account key is loaded from
+// keyFile or, if the file does not exist, generated and saved to it. Reusing
+// the account key across restarts avoids registering a new account, and
+// consuming the CA's rate limits, on every start.
+func PersistentACMEClient(keyFile string) (*acme.Client, error) {
+	key, err := readACMEKey(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return nil, err
+		}
+		err = writeACMEKey(keyFile, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("acme: account key: %v", err)
+	}
+	return &acme.Client{Key: key}, nil
+}
+
+// RotateACMEKey replaces the ACME account key in keyFile with a new key,
+// informing the CA of the change (RFC 8555, 7.3.5). The file is replaced
+// atomically once the CA has accepted the new key.
+func RotateACMEKey(keyFile string) error {
+	client, err := PersistentACMEClient(keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := client.AccountKeyRollover(ctx, key); err != nil {
+		return fmt.Errorf("acme: key rollover: %v", err)
+	}
+	return writeACMEKey(keyFile, key)
+}
+
+func readACMEKey(keyFile string) (*ecdsa.PrivateKey, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil || blk.Type != "EC PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: no EC private key found", keyFile)
+	}
+	return x509.ParseECPrivateKey(blk.Bytes)
+}
+
+// writeACMEKey atomically writes key to keyFile.
+func writeACMEKey(keyFile string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(keyFile), filepath.Base(keyFile)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), keyFile)
+}
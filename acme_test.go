@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPersistentACMEClient(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "acme", acmeKeyFile)
+	c1, err := PersistentACMEClient(keyFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fi, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0600 {
+		t.Errorf("key file mode = %v, want 0600", mode)
+	}
+
+	c2, err := PersistentACMEClient(keyFile)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !c1.Key.(*ecdsa.PrivateKey).Equal(c2.Key) {
+		t.Errorf("account key not reused across clients")
+	}
+}
+
+func TestPersistentACMEClientBadKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), acmeKeyFile)
+	if err := os.WriteFile(keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := PersistentACMEClient(keyFile); err == nil {
+		t.Errorf("expected error for malformed key file")
+	}
+}
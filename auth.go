@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicAuth returns a middleware requiring HTTP Basic authentication
+// (RFC 7617) with credentials accepted by validator.
+func BasicAuth(realm string, validator func(user, password string) bool) Middleware {
+	challenge := fmt.Sprintf("Basic realm=%q", realm)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			if !ok || !validator(user, password) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// StaticCredentials returns a BasicAuth validator for a fixed map of users to
+// passwords. Every entry is compared in constant time, so that response times
+// reveal neither the users nor their passwords.
+func StaticCredentials(credentials map[string]string) func(user, password string) bool {
+	return func(user, password string) bool {
+		found := 0
+		for u, p := range credentials {
+			match := subtle.ConstantTimeCompare([]byte(u), []byte(user)) &
+				subtle.ConstantTimeCompare([]byte(p), []byte(password))
+			found |= match
+		}
+		return found == 1
+	}
+}
+
+// htpasswdDummyHash is compared against when the user is unknown, so that
+// response times do not reveal which users exist.
+var htpasswdDummyHash = []byte("$2a$10$7EqJtq98hPqEX7fNZaFWoOhi5BWX4Z8vrgUaaP6VJ8lAkI1mLmwwi")
+
+// HtpasswdValidator returns a BasicAuth validator for the users in an
+// htpasswd file. Only bcrypt password hashes (htpasswd -B) are supported.
+func HtpasswdValidator(path string) (func(user, password string) bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	type entry struct {
+		user []byte
+		hash []byte
+	}
+	var entries []entry
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || !strings.HasPrefix(hash, "$2") {
+			return nil, fmt.Errorf("%s:%d: expected user:bcrypt-hash", path, n)
+		}
+		entries = append(entries, entry{[]byte(user), []byte(hash)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return func(user, password string) bool {
+		hash := htpasswdDummyHash
+		found := 0
+		for _, e := range entries {
+			if subtle.ConstantTimeCompare(e.user, []byte(user)) == 1 {
+				hash = e.hash
+				found = 1
+			}
+		}
+		ok := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+		return ok && found == 1
+	}, nil
+}
+
+// APIKey returns a middleware authenticating requests by the key in the named
+// header, e.g. X-API-Key. validate maps a key to the principal it identifies;
+// the principal is available to later handlers through PrincipalFromContext.
+func APIKey(header string, validate func(key string) (principal string, ok bool)) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(header)
+			principal, ok := validate(key)
+			if key == "" || !ok {
+				ip, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					ip = r.RemoteAddr
+				}
+				logger.Printf("api key rejected: client %s key %q", ip, redactKey(key))
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, principal)))
+		})
+	}
+}
+
+// PrincipalFromContext returns the principal authenticated by APIKey, if any.
+func PrincipalFromContext(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey).(string)
+	return p
+}
+
+// StaticAPIKeys returns an APIKey validator for a fixed map of keys to
+// principals.
+func StaticAPIKeys(keys map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		var principal string
+		found := 0
+		for k, p := range keys {
+			if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+				principal = p
+				found = 1
+			}
+		}
+		return principal, found == 1
+	}
+}
+
+// redactKey returns the first four characters of key, so that rejected keys
+// can be identified in logs without disclosing them.
+func redactKey(key string) string {
+	if len(key) > 4 {
+		key = key[:4]
+	}
+	return key + "****"
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAPIKey(t *testing.T) {
+	keys := StaticAPIKeys(map[string]string{"s3cr3t-key": "deploy-bot"})
+	var got string
+	h := APIKey("X-API-Key", keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = PrincipalFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		key       string
+		status    int
+		principal string
+	}{
+		{"s3cr3t-key", http.StatusOK, "deploy-bot"},
+		{"wrong", http.StatusUnauthorized, ""},
+		{"", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		got = ""
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.key != "" {
+			r.Header.Set("X-API-Key", tt.key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status || got != tt.principal {
+			t.Errorf("key %q: got (%d, %q), want (%d, %q)", tt.key, w.Code, got, tt.status, tt.principal)
+		}
+	}
+}
+
+func TestBasicAuthStaticCredentials(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	auth := BasicAuth("private", StaticCredentials(map[string]string{"alice": "s3cr3t"}))
+	h := Apply(Log, Beneath("/private", auth))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		path, user, password string
+		status               int
+	}{
+		{"/private/a.html", "", "", http.StatusUnauthorized},
+		{"/private", "", "", http.StatusUnauthorized},
+		{"/private/a.html", "alice", "wrong", http.StatusUnauthorized},
+		{"/private/a.html", "bob", "s3cr3t", http.StatusUnauthorized},
+		{"/private/a.html", "alice", "s3cr3t", http.StatusOK},
+		{"/privateer", "", "", http.StatusOK},
+		{"/index.html", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s as %q: got status %d, want %d", tt.path, tt.user, w.Code, tt.status)
+		}
+		challenge := w.Header().Get("WWW-Authenticate")
+		if (tt.status == http.StatusUnauthorized) != (challenge == `Basic realm="private"`) {
+			t.Errorf("%s as %q: got challenge %q", tt.path, tt.user, challenge)
+		}
+	}
+
+	if !strings.Contains(buf.String(), ` alice `) {
+		t.Errorf("log does not record the authenticated user:\n%s", buf.String())
+	}
+}
+
+func TestHtpasswdValidator(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cr3t"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	bcryptLine := "alice:" + string(hash) + "\n"
+
+	type check struct {
+		user, password string
+		ok             bool
+	}
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+		checks  []check
+	}{
+		{"bcrypt", "# users\n\n" + bcryptLine, false, []check{
+			{"alice", "s3cr3t", true},
+			{"alice", "wrong", false},
+			{"bob", "s3cr3t", false},
+			{"", "", false},
+		}},
+		// {SHA} hashes are unsalted and not accepted.
+		{"sha", "alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n", true, nil},
+		{"unknown hash", "alice:$apr1$salt$hash\n", true, nil},
+		{"plain text", "alice:s3cr3t\n", true, nil},
+		{"no colon", bcryptLine + "bob\n", true, nil},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(t.TempDir(), ".htpasswd")
+		if err := os.WriteFile(name, []byte(tt.data), 0600); err != nil {
+			t.Fatalf("%v", err)
+		}
+		validate, err := HtpasswdValidator(name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		for _, c := range tt.checks {
+			if ok := validate(c.user, c.password); ok != c.ok {
+				t.Errorf("%s: %q/%q accepted = %v, want %v", tt.name, c.user, c.password, ok, c.ok)
+			}
+		}
+	}
+
+	if _, err := HtpasswdValidator(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: err = %v, want %v", err, fs.ErrNotExist)
+	}
+}
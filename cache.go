@@ -0,0 +1,134 @@
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lruCache is a fixed-size cache of responses with least recently used
+// eviction.
+type lruCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	entries    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key     string
+	resp    *CachedResponse
+	expires time.Time
+}
+
+func newLRUCache(maxEntries int) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	ent := e.Value.(*lruEntry)
+	if time.Now().After(ent.expires) {
+		c.ll.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return ent.resp, true
+}
+
+func (c *lruCache) add(key string, resp *CachedResponse, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.ll.MoveToFront(e)
+		ent := e.Value.(*lruEntry)
+		ent.resp, ent.expires = resp, time.Now().Add(ttl)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(&lruEntry{key, resp, time.Now().Add(ttl)})
+	if c.ll.Len() > c.maxEntries {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.entries, e.Value.(*lruEntry).key)
+	}
+}
+
+// hasCacheDirective reports whether the Cache-Control header h contains the
+// directive d, with or without an argument.
+func hasCacheDirective(h http.Header, d string) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, f := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(strings.TrimSpace(f), "=")
+			if strings.EqualFold(name, d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ResponseCache returns a middleware caching complete 200 responses to GET
+// requests in memory for ttl, keyed by host and request URI, and answering
+// GET and HEAD requests from them. At most maxEntries responses are held,
+// evicting the least recently used. Requests with Cache-Control: no-cache
+// bypass the cache. Responses with Cache-Control: no-store or private are not
+// cached, nor are those with a Vary header, as the key does not include the
+// request headers they vary by. As required by RFC 9111 section 3.5,
+// responses to requests with an Authorization header are cached only if
+// marked public or s-maxage, so that they are not replayed to other clients.
+func ResponseCache(maxEntries int, ttl time.Duration) Middleware {
+	c := newLRUCache(maxEntries)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+			key := strings.ToLower(r.Host) + r.URL.RequestURI()
+			if !hasCacheDirective(r.Header, "no-cache") {
+				if resp, ok := c.get(key); ok {
+					resp.replay(w)
+					return
+				}
+			}
+			if r.Method == http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			buf := newResponseBuffer()
+			h.ServeHTTP(buf, r)
+			resp := buf.response()
+			if cacheable(r, resp) {
+				c.add(key, resp, ttl)
+			}
+			resp.replay(w)
+		})
+	}
+}
+
+// cacheable reports whether resp, the response to r, may be stored by
+// ResponseCache.
+func cacheable(r *http.Request, resp *CachedResponse) bool {
+	if resp.Status != http.StatusOK || resp.Header.Get("Vary") != "" ||
+		hasCacheDirective(resp.Header, "no-store") || hasCacheDirective(resp.Header, "private") {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		return hasCacheDirective(resp.Header, "public") || hasCacheDirective(resp.Header, "s-maxage")
+	}
+	return true
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	a, b, d := &CachedResponse{Status: 1}, &CachedResponse{Status: 2}, &CachedResponse{Status: 3}
+	c.add("a", a, time.Hour)
+	c.add("b", b, time.Hour)
+	c.get("a") // b is now least recently used.
+	c.add("d", d, time.Hour)
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("least recently used entry not evicted")
+	}
+	for key, want := range map[string]*CachedResponse{"a": a, "d": d} {
+		if got, ok := c.get(key); !ok || got != want {
+			t.Errorf("%s: got (%v, %v), want (%v, true)", key, got, ok, want)
+		}
+	}
+	if n := c.ll.Len(); n != 2 || len(c.entries) != 2 {
+		t.Errorf("holding %d list and %d map entries, want 2", n, len(c.entries))
+	}
+
+	c.add("a", b, -time.Second)
+	if _, ok := c.get("a"); ok {
+		t.Errorf("expired entry returned")
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("expired entry not removed")
+	}
+}
+
+func TestResponseCache(t *testing.T) {
+	var calls atomic.Int32
+	h := ResponseCache(10, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/vary":
+			w.Header().Set("Vary", "Accept-Encoding")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/private":
+			w.Header().Set("Cache-Control", "max-age=60, private")
+		case "/missing":
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Call", fmt.Sprint(n))
+		fmt.Fprintf(w, "call %d", n)
+	}))
+
+	get := func(method, path string, hdr ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		for i := 0; i+1 < len(hdr); i += 2 {
+			r.Header.Set(hdr[i], hdr[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		hdr    []string
+		cached bool
+	}{
+		{"GET", "GET", "/a", nil, true},
+		{"query", "GET", "/a?x=1", nil, true},
+		{"no-cache request", "GET", "/a", []string{"Cache-Control", "no-cache"}, false},
+		{"Vary", "GET", "/vary", nil, false},
+		{"no-store", "GET", "/no-store", nil, false},
+		{"private", "GET", "/private", nil, false},
+		{"not found", "GET", "/missing", nil, false},
+		{"POST", "POST", "/post", nil, false},
+		{"HEAD", "HEAD", "/head", nil, false},
+	}
+	for _, tt := range tests {
+		first := get(tt.method, tt.path)
+		before := calls.Load()
+		second := get(tt.method, tt.path, tt.hdr...)
+		if cached := calls.Load() == before; cached != tt.cached {
+			t.Errorf("%s: cached = %v, want %v", tt.name, cached, tt.cached)
+		}
+		if tt.cached && (second.Body.String() != first.Body.String() || second.Header().Get("X-Call") != first.Header().Get("X-Call")) {
+			t.Errorf("%s: replayed %q, want %q", tt.name, second.Body.String(), first.Body.String())
+		}
+	}
+
+	// HEAD is answered from a response cached for GET.
+	want := get("GET", "/b").Header().Get("X-Call")
+	before := calls.Load()
+	if got := get("HEAD", "/b").Header().Get("X-Call"); got != want || calls.Load() != before {
+		t.Errorf("HEAD: got X-Call %q after %d calls, want %q from cache", got, calls.Load()-before, want)
+	}
+}
+
+func TestResponseCacheLimit(t *testing.T) {
+	var calls atomic.Int32
+	h := ResponseCache(2, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+	for _, path := range []string{"/1", "/2", "/3", "/1"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if n := calls.Load(); n != 4 {
+		t.Errorf("handler called %d times, want 4: /1 should have been evicted", n)
+	}
+}
+
+func TestResponseCacheConcurrent(t *testing.T) {
+	h := ResponseCache(4, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				path := fmt.Sprintf("/%d", (i+j)%6)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+				if w.Body.String() != path {
+					t.Errorf("%s: got body %q", path, w.Body.String())
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestResponseCacheAuthorization(t *testing.T) {
+	var calls atomic.Int32
+	auth := BasicAuth("private", StaticCredentials(map[string]string{"alice": "s3cr3t"}))
+	h := Apply(ResponseCache(10, time.Hour), auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		io.WriteString(w, "secret")
+	}))
+
+	get := func(path string, authenticated bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if authenticated {
+			r.SetBasicAuth("alice", "s3cr3t")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/secret", true); w.Code != http.StatusOK {
+		t.Fatalf("authenticated: got %d, want 200", w.Code)
+	}
+	if w := get("/secret", false); w.Code != http.StatusUnauthorized || w.Body.String() == "secret" {
+		t.Errorf("unauthenticated: got %d %q, want 401 without the authenticated body", w.Code, w.Body.String())
+	}
+
+	get("/public", true)
+	before := calls.Load()
+	if w := get("/public", false); w.Code != http.StatusOK || calls.Load() != before {
+		t.Errorf("public response to an authenticated request not cached")
+	}
+}
+
+func TestResponseCacheHost(t *testing.T) {
+	h := ResponseCache(10, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	for _, host := range []string{"a.example", "b.example", "a.example", "B.example"} {
+		r := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := strings.ToLower(w.Body.String()); got != strings.ToLower(host) {
+			t.Errorf("%s: got body %q", host, w.Body.String())
+		}
+	}
+}
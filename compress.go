@@ -0,0 +1,116 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressibleExts are the extensions of text files worth pre-compressing.
+var compressibleExts = map[string]bool{
+	".html": true,
+	".htm":  true,
+	".css":  true,
+	".js":   true,
+	".mjs":  true,
+	".svg":  true,
+	".json": true,
+}
+
+// DefaultCompressMinSize is the size, in bytes, below which files are not
+// pre-compressed, as the savings do not outweigh the extra request overhead.
+const DefaultCompressMinSize = 1024
+
+// compressDir writes Brotli (.br) and gzip (.gz) compressed copies, at maximum
+// quality, of each text file in dir of at least minSize bytes, reporting
+// progress to w.
+func compressDir(dir string, minSize int64, w io.Writer) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if !compressibleExts[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fi.Size() < minSize {
+			return nil
+		}
+		br, err := compressFile(path, ".br", func(w io.Writer) (io.WriteCloser, error) {
+			return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+		})
+		if err != nil {
+			return err
+		}
+		gz, err := compressFile(path, ".gz", func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: %d bytes, br %d, gz %d\n", path, fi.Size(), br, gz)
+		return nil
+	})
+}
+
+// compressFile writes a copy of the named file, compressed by the writer
+// returned by newWriter, to the file name+ext, returning its size.
+func compressFile(name, ext string, newWriter func(io.Writer) (io.WriteCloser, error)) (int64, error) {
+	in, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	out, err := os.Create(name + ext)
+	if err != nil {
+		return 0, err
+	}
+	zw, err := newWriter(out)
+	if err != nil {
+		out.Close()
+		return 0, err
+	}
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return 0, err
+	}
+	fi, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return 0, err
+	}
+	return fi.Size(), out.Close()
+}
+
+// compressMain implements the compress subcommand, pre-compressing the text
+// files under the static directory.
+func compressMain(args []string) int {
+	fl := flag.NewFlagSet("compress", flag.ExitOnError)
+	dir := fl.String("fsdir", "static", "file system directory")
+	minSize := fl.Int64("min-size", DefaultCompressMinSize, "minimum size in bytes of files compressed")
+	fl.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: site compress [-fsdir dir] [-min-size bytes]\n")
+		fl.PrintDefaults()
+	}
+	fl.Parse(args)
+
+	if err := compressDir(*dir, *minSize, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "site compress: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressDir(t *testing.T) {
+	dir := t.TempDir()
+	page := strings.Repeat("<p>hello, world</p>\n", 100)
+	files := map[string]string{
+		"index.html": page,
+		"small.css":  "body{}",
+		"logo.png":   page,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	var progress bytes.Buffer
+	if err := compressDir(dir, 64, &progress); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !strings.Contains(progress.String(), "index.html") {
+		t.Errorf("progress %q does not mention index.html", progress.String())
+	}
+
+	readAll := func(name string, newReader func(io.Reader) (io.Reader, error)) string {
+		t.Helper()
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer f.Close()
+		r, err := newReader(f)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return string(b)
+	}
+	if got := readAll("index.html.br", func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }); got != page {
+		t.Errorf("index.html.br does not decompress to the original")
+	}
+	if got := readAll("index.html.gz", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }); got != page {
+		t.Errorf("index.html.gz does not decompress to the original")
+	}
+
+	for _, name := range []string{"small.css.br", "small.css.gz", "logo.png.br", "index.html.br.br", "index.html.gz.gz"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("unexpected file %s", name)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// LoadConfigFile reads the settings in the named TOML or JSON file, selected
+// by its extension. Settings are named after the command-line flags, e.g.
+//
+//	addr = ":443"
+//	hosts = "example.com,www.example.com"
+//	mount = ["/docs=/srv/docs", "/img=/srv/img"]
+//
+// Each setting maps to one or more values; arrays are used for repeatable
+// flags such as -mount. Tables are not supported.
+func LoadConfigFile(name string) (map[string][]string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("config: %v", err)
+	}
+	var settings map[string][]string
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".json":
+		settings, err = parseJSONConfig(b)
+	case ".toml":
+		settings, err = parseTOMLConfig(b)
+	default:
+		return nil, fmt.Errorf("config: %s: unknown format %q (want .toml or .json)", name, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %v", name, err)
+	}
+	return settings, nil
+}
+
+// ApplyConfig sets the flags of fs named by settings, except those already
+// set on the command line or by ApplyEnv, so that the configuration file
+// takes precedence only over defaults.
+func ApplyConfig(fs *flag.FlagSet, settings map[string][]string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("config: unknown setting %q", name)
+		}
+		if explicit[name] {
+			continue
+		}
+		for _, v := range settings[name] {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("config: %s: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// EnvPrefix is the prefix of the environment variables read by ApplyEnv.
+const EnvPrefix = "SITE_"
+
+// ApplyEnv sets each flag of fs not set on the command line from the
+// environment variable named by prefix and the flag name in upper case, with
+// '-' replaced by '_', e.g. SITE_LOG_OUTPUT for -log-output. It is applied
+// before ApplyConfig, so that flags take precedence over the environment,
+// which takes precedence over the configuration file.
+func ApplyEnv(fs *flag.FlagSet, prefix string) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := prefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		v, ok := os.LookupEnv(name)
+		if !ok || explicit[f.Name] || err != nil {
+			return
+		}
+		if e := fs.Set(f.Name, v); e != nil {
+			err = fmt.Errorf("config: %s: %v", name, e)
+		}
+	})
+	return err
+}
+
+func parseJSONConfig(b []byte) (map[string][]string, error) {
+	var raw map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	return configSettings(raw)
+}
+
+func parseTOMLConfig(b []byte) (map[string][]string, error) {
+	var raw map[string]any
+	if _, err := toml.Decode(string(b), &raw); err != nil {
+		return nil, err
+	}
+	return configSettings(raw)
+}
+
+// configSettings converts decoded settings to flag values. Each setting is
+// a scalar or an array of scalars.
+func configSettings(raw map[string]any) (map[string][]string, error) {
+	settings := make(map[string][]string, len(raw))
+	for k, v := range raw {
+		vs := []any{v}
+		if a, ok := v.([]any); ok {
+			vs = a
+		}
+		for _, v := range vs {
+			switch v := v.(type) {
+			case string:
+				settings[k] = append(settings[k], v)
+			case json.Number:
+				settings[k] = append(settings[k], v.String())
+			case int64:
+				settings[k] = append(settings[k], strconv.FormatInt(v, 10))
+			case float64:
+				settings[k] = append(settings[k], strconv.FormatFloat(v, 'g', -1, 64))
+			case bool:
+				settings[k] = append(settings[k], strconv.FormatBool(v))
+			default:
+				return nil, fmt.Errorf("%s: unsupported value %v", k, v)
+			}
+		}
+	}
+	return settings, nil
+}
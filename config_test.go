@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	want := map[string][]string{
+		"addr":         {":443"},
+		"s":            {"false"},
+		"max-uri-len":  {"1024"},
+		"idle-timeout": {"2m"},
+		"mount":        {"/docs=/srv/docs", "/img=/srv/img"},
+	}
+	files := map[string]string{
+		"site.toml": `# site configuration
+addr = ":443"
+s = false # comment
+max-uri-len = 1_024
+idle-timeout = '2m'
+mount = ["/docs=/srv/docs", "/img=/srv/img"]
+`,
+		"site.json": `{
+	"addr": ":443",
+	"s": false,
+	"max-uri-len": 1024,
+	"idle-timeout": "2m",
+	"mount": ["/docs=/srv/docs", "/img=/srv/img"]
+}`,
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		got, err := LoadConfigFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	files := map[string]string{
+		"table.toml":   "[server]\naddr = \":443\"\n",
+		"noval.toml":   "addr\n",
+		"bad.toml":     "addr = :443\n",
+		"unterm.toml":  "addr = \":443\n",
+		"trailer.toml": "addr = \":443\" x\n",
+		"dup.toml":     "addr = \":443\"\naddr = \":80\"\n",
+		"object.json":  `{"addr": {"port": 443}}`,
+		"site.yaml":    "addr: :443\n",
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := LoadConfigFile(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	fs := flag.NewFlagSet("site", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	addr := fs.String("addr", ":4433", "")
+	selfSign := fs.Bool("s", true, "")
+	fsDir := fs.String("fsdir", "static", "")
+	idle := fs.Duration("idle-timeout", time.Minute, "")
+	var mts mountFlags
+	fs.Var(&mts, "mount", "")
+
+	if err := fs.Parse([]string{"-addr", ":8443"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	err := ApplyConfig(fs, map[string][]string{
+		"addr":         {":443"},
+		"s":            {"false"},
+		"idle-timeout": {"2m"},
+		"mount":        {"/docs=/srv/docs", "/img=/srv/img"},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if *addr != ":8443" {
+		t.Errorf("addr = %q, want the explicit flag :8443", *addr)
+	}
+	if *selfSign {
+		t.Errorf("s = true, want false from the config file")
+	}
+	if *idle != 2*time.Minute {
+		t.Errorf("idle-timeout = %v, want 2m", *idle)
+	}
+	if *fsDir != "static" {
+		t.Errorf("fsdir = %q, want the default", *fsDir)
+	}
+	if want := (mountFlags{{"/docs", "/srv/docs"}, {"/img", "/srv/img"}}); !reflect.DeepEqual(mts, want) {
+		t.Errorf("mount = %v, want %v", mts, want)
+	}
+
+	fs = flag.NewFlagSet("site", flag.ContinueOnError)
+	fs.Duration("idle-timeout", time.Minute, "")
+	if err := ApplyConfig(fs, map[string][]string{"nonesuch": {"1"}}); err == nil {
+		t.Errorf("expected error for unknown setting")
+	}
+	if err := ApplyConfig(fs, map[string][]string{"idle-timeout": {"soon"}}); err == nil {
+		t.Errorf("expected error for invalid value")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	fs := flag.NewFlagSet("site", flag.ContinueOnError)
+	addr := fs.String("addr", ":4433", "")
+	logOutput := fs.String("log-output", "stdout", "")
+	fsDir := fs.String("fsdir", "static", "")
+	selfSign := fs.Bool("s", true, "")
+	t.Setenv("SITE_ADDR", ":8080")
+	t.Setenv("SITE_LOG_OUTPUT", "stderr")
+	t.Setenv("SITE_FSDIR", "/srv/env")
+
+	if err := fs.Parse([]string{"-addr", ":8443"}); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := ApplyEnv(fs, EnvPrefix); err != nil {
+		t.Fatalf("%v", err)
+	}
+	err := ApplyConfig(fs, map[string][]string{
+		"fsdir": {"/srv/file"},
+		"s":     {"false"},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if *addr != ":8443" {
+		t.Errorf("addr = %q, want the flag :8443", *addr)
+	}
+	if *logOutput != "stderr" {
+		t.Errorf("log-output = %q, want the environment's stderr", *logOutput)
+	}
+	if *fsDir != "/srv/env" {
+		t.Errorf("fsdir = %q, want the environment's /srv/env over the file's", *fsDir)
+	}
+	if *selfSign {
+		t.Errorf("s = true, want the file's false")
+	}
+
+	t.Setenv("SITE_S", "maybe")
+	fs = flag.NewFlagSet("site", flag.ContinueOnError)
+	fs.Bool("s", true, "")
+	if err := ApplyEnv(fs, EnvPrefix); err == nil {
+		t.Errorf("expected error for invalid SITE_S")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// connMetrics holds the connection statistics of the running server.
+var connMetrics ConnMetrics
+
+// ConnMetrics counts connections and the bytes transferred over them. Its
+// zero value is ready to use.
+type ConnMetrics struct {
+	open, idle, active atomic.Int64
+	bytesIn, bytesOut  atomic.Int64
+
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+}
+
+// ConnSummary is a snapshot of ConnMetrics.
+type ConnSummary struct {
+	Open, Idle, Active int64 // Connections by state
+	BytesIn, BytesOut  int64 // Total bytes read and written
+}
+
+// Summary returns a snapshot of the current connection statistics.
+func (m *ConnMetrics) Summary() ConnSummary {
+	return ConnSummary{
+		Open:     m.open.Load(),
+		Idle:     m.idle.Load(),
+		Active:   m.active.Load(),
+		BytesIn:  m.bytesIn.Load(),
+		BytesOut: m.bytesOut.Load(),
+	}
+}
+
+// Listener returns a listener counting the connections accepted by l and the
+// bytes transferred over them.
+func (m *ConnMetrics) Listener(l net.Listener) net.Listener {
+	return &meteredListener{l, m}
+}
+
+// ConnState updates the idle and active connection counts. It is suitable for
+// use as http.Server.ConnState.
+func (m *ConnMetrics) ConnState(c net.Conn, state http.ConnState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.states == nil {
+		m.states = make(map[net.Conn]http.ConnState)
+	}
+	m.gauge(m.states[c]).Add(-1)
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(m.states, c)
+	default:
+		m.states[c] = state
+		m.gauge(state).Add(1)
+	}
+}
+
+// gauge returns the counter of connections in state. New connections are
+// counted as neither idle nor active.
+func (m *ConnMetrics) gauge(state http.ConnState) *atomic.Int64 {
+	switch state {
+	case http.StateIdle:
+		return &m.idle
+	case http.StateActive:
+		return &m.active
+	}
+	return new(atomic.Int64)
+}
+
+type meteredListener struct {
+	net.Listener
+	m *ConnMetrics
+}
+
+func (l *meteredListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.m.open.Add(1)
+	return &meteredConn{Conn: c, m: l.m}, nil
+}
+
+type meteredConn struct {
+	net.Conn
+	m    *ConnMetrics
+	once sync.Once
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.m.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.m.bytesOut.Add(int64(n))
+	return n, err
+}
+
+func (c *meteredConn) Close() error {
+	c.once.Do(func() { c.m.open.Add(-1) })
+	return c.Conn.Close()
+}
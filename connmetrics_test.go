@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConnMetrics(t *testing.T) {
+	var m ConnMetrics
+	active := make(chan ConnSummary, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		active <- m.Summary()
+		io.WriteString(w, "ok")
+	}))
+	ts.Listener = m.Listener(ts.Listener)
+	ts.Config.ConnState = m.ConnState
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if s := <-active; s.Open != 1 || s.Active != 1 || s.Idle != 0 {
+		t.Errorf("during request: got %+v, want 1 open and active connection", s)
+	}
+
+	ts.CloseClientConnections()
+	ts.Close()
+	s := m.Summary()
+	if s.Open != 0 || s.Active != 0 || s.Idle != 0 {
+		t.Errorf("after close: got %+v, want no connections", s)
+	}
+	if s.BytesIn == 0 || s.BytesOut == 0 {
+		t.Errorf("after close: got %+v, want bytes transferred", s)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// DNSProvider publishes the TXT records used to answer ACME DNS-01
+// challenges (RFC 8555, 8.4). Implementations typically wrap the API of a
+// DNS hosting service.
+type DNSProvider interface {
+	// SetTXT creates a TXT record for the fully-qualified name.
+	SetTXT(ctx context.Context, name, value string) error
+
+	// RemoveTXT removes a TXT record previously created by SetTXT.
+	RemoveTXT(ctx context.Context, name, value string) error
+}
+
+// dns01Client is the subset of acme.Client used to solve DNS-01 challenges.
+type dns01Client interface {
+	GetAuthorization(ctx context.Context, url string) (*acme.Authorization, error)
+	Accept(ctx context.Context, chal *acme.Challenge) (*acme.Challenge, error)
+	WaitAuthorization(ctx context.Context, url string) (*acme.Authorization, error)
+	DNS01ChallengeRecord(token string) (string, error)
+}
+
+// solveDNS01 satisfies the authorization at authzURL by publishing a TXT record
+// through p. The record is removed once the authorization completes.
+func solveDNS01(ctx context.Context, client dns01Client, p DNSProvider, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("acme: no dns-01 challenge for %q", authz.Identifier.Value)
+	}
+
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	name := "_acme-challenge." + authz.Identifier.Value + "."
+	if err := p.SetTXT(ctx, name, value); err != nil {
+		return fmt.Errorf("dns-01: set %s: %v", name, err)
+	}
+	defer func() {
+		if err := p.RemoveTXT(ctx, name, value); err != nil {
+			logger.Printf("dns-01: remove %s: %v", name, err)
+		}
+	}()
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authzURL)
+	return err
+}
+
+// dns01Cert obtains a certificate for domains from the ACME CA, solving
+// DNS-01 challenges with p.
+func dns01Cert(ctx context.Context, client *acme.Client, p DNSProvider, domains []string) (*tls.Certificate, error) {
+	if client.Key == nil {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		client.Key = key
+	}
+	_, err := client.Register(ctx, &acme.Account{}, autocert.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domains...))
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range order.AuthzURLs {
+		if err := solveDNS01(ctx, client, p, u); err != nil {
+			return nil, err
+		}
+	}
+	if order, err = client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// dns01Renewal is the time before expiry at which certificates are renewed.
+const dns01Renewal = 30 * 24 * time.Hour
+
+// dns01X509 returns a CertProvider for domains, issuing certificates using
+// DNS-01 challenges. Certificates are stored in dirCache and checked for
+// renewal twice daily, or on Reload.
+func dns01X509(dirCache string, client *acme.Client, p DNSProvider, domains []string) (*CertProvider, error) {
+	if len(domains) == 0 {
+		return nil, errors.New("dns-01: no hosts to issue a certificate for")
+	}
+	cache := autocert.DirCache(dirCache)
+	name := "dns01+" + domains[0]
+
+	load := func() (*tls.Certificate, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		if data, err := cache.Get(ctx, name); err == nil {
+			cert, err := tls.X509KeyPair(data, data)
+			if err == nil {
+				cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			}
+			if err == nil && time.Until(cert.Leaf.NotAfter) > dns01Renewal {
+				return &cert, nil
+			}
+		}
+
+		cert, err := dns01Cert(ctx, client, p, domains)
+		if err != nil {
+			return nil, err
+		}
+		key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+		for _, b := range cert.Certificate {
+			data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+		}
+		if err := cache.Put(ctx, name, data); err != nil {
+			logger.Printf("dns-01: cache: %v", err)
+		}
+		return cert, nil
+	}
+
+	cp := &CertProvider{load: oneCert(load)}
+	if err := cp.Reload(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cp.stop = cancel
+	go reloadEvery(ctx, cp, dns01Check)
+	return cp, nil
+}
+
+// dns01Check is the interval at which DNS-01 certificates are checked for
+// renewal.
+const dns01Check = 12 * time.Hour
+
+// reloadEvery reloads p at each interval d until ctx is done.
+func reloadEvery(ctx context.Context, p *CertProvider, d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := p.Reload(); err != nil {
+				logger.Printf("dns-01: renew: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// fakeDNSProvider records the operations performed on TXT records.
+type fakeDNSProvider struct {
+	ops *[]string
+}
+
+func (p fakeDNSProvider) SetTXT(ctx context.Context, name, value string) error {
+	*p.ops = append(*p.ops, "set "+name+" "+value)
+	return nil
+}
+
+func (p fakeDNSProvider) RemoveTXT(ctx context.Context, name, value string) error {
+	*p.ops = append(*p.ops, "remove "+name+" "+value)
+	return nil
+}
+
+// fakeDNS01Client is an ACME client with a single pending authorization.
+type fakeDNS01Client struct {
+	ops *[]string
+}
+
+func (c fakeDNS01Client) GetAuthorization(ctx context.Context, url string) (*acme.Authorization, error) {
+	return &acme.Authorization{
+		URI:        url,
+		Status:     acme.StatusPending,
+		Identifier: acme.AuthzID{Type: "dns", Value: "example.com"},
+		Challenges: []*acme.Challenge{
+			{Type: "http-01", Token: "http-token"},
+			{Type: "dns-01", Token: "dns-token"},
+		},
+	}, nil
+}
+
+func (c fakeDNS01Client) Accept(ctx context.Context, chal *acme.Challenge) (*acme.Challenge, error) {
+	*c.ops = append(*c.ops, "accept "+chal.Type)
+	return chal, nil
+}
+
+func (c fakeDNS01Client) WaitAuthorization(ctx context.Context, url string) (*acme.Authorization, error) {
+	*c.ops = append(*c.ops, "wait "+url)
+	return &acme.Authorization{URI: url, Status: acme.StatusValid}, nil
+}
+
+func (c fakeDNS01Client) DNS01ChallengeRecord(token string) (string, error) {
+	return "record-" + token, nil
+}
+
+func TestSolveDNS01(t *testing.T) {
+	var ops []string
+	err := solveDNS01(context.Background(), fakeDNS01Client{&ops}, fakeDNSProvider{&ops}, "https://ca/authz/1")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	want := []string{
+		"set _acme-challenge.example.com. record-dns-token",
+		"accept dns-01",
+		"wait https://ca/authz/1",
+		"remove _acme-challenge.example.com. record-dns-token",
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("got operations %q, want %q", ops, want)
+	}
+}
+
+func TestDNS01X509NoHosts(t *testing.T) {
+	if _, err := dns01X509(t.TempDir(), &acme.Client{}, nil, nil); err == nil {
+		t.Errorf("expected error without hosts")
+	}
+}
+
+func TestReloadEvery(t *testing.T) {
+	var reloads atomic.Int32
+	p := &CertProvider{load: func() ([]tls.Certificate, error) {
+		reloads.Add(1)
+		return nil, nil
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		reloadEvery(ctx, p, time.Millisecond)
+		close(done)
+	}()
+	for reloads.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("reloadEvery did not stop when its context was cancelled")
+	}
+}
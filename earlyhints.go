@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HintLink is a resource which the client may fetch, or connect to, while the
+// response is being prepared.
+type HintLink struct {
+	URL string
+	Rel string // preload, preconnect or prefetch
+	As  string // Destination of preloaded resources, e.g. script, style or font
+}
+
+// String returns l in Link header syntax (RFC 8288).
+func (l HintLink) String() string {
+	var b strings.Builder
+	b.WriteString("<" + l.URL + ">; rel=" + l.Rel)
+	if l.As != "" {
+		b.WriteString("; as=" + l.As)
+	}
+	if l.As == "font" {
+		// Fonts are always fetched in CORS mode.
+		b.WriteString("; crossorigin")
+	}
+	return b.String()
+}
+
+// EarlyHints returns a middleware sending a 103 Early Hints response (RFC
+// 8297) with Link headers for links before calling the next handler. The
+// headers are also sent with the final response. HTTP/1.1 requests are passed
+// through, as clients and intermediaries may mishandle informational
+// responses.
+func EarlyHints(links []HintLink) Middleware {
+	values := make([]string, len(links))
+	for i, l := range links {
+		values[i] = l.String()
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor >= 2 && len(values) > 0 {
+				for _, v := range values {
+					w.Header().Add("Link", v)
+				}
+				w.WriteHeader(http.StatusEarlyHints)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
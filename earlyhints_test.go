@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"reflect"
+	"testing"
+)
+
+func TestEarlyHints(t *testing.T) {
+	links := []HintLink{
+		{URL: "/css/site.css", Rel: "preload", As: "style"},
+		{URL: "/fonts/a.woff2", Rel: "preload", As: "font"},
+		{URL: "https://cdn.example.com", Rel: "preconnect"},
+	}
+	want := []string{
+		"</css/site.css>; rel=preload; as=style",
+		"</fonts/a.woff2>; rel=preload; as=font; crossorigin",
+		"<https://cdn.example.com>; rel=preconnect",
+	}
+	h := EarlyHints(links)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	for _, http2 := range []bool{true, false} {
+		ts := httptest.NewUnstartedServer(h)
+		ts.EnableHTTP2 = http2
+		ts.StartTLS()
+
+		var hints []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == http.StatusEarlyHints {
+					hints = header["Link"]
+				}
+				return nil
+			},
+		}
+		ctx := httptrace.WithClientTrace(context.Background(), trace)
+		req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		resp.Body.Close()
+		ts.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d, want 200", resp.Proto, resp.StatusCode)
+		}
+		if http2 && !reflect.DeepEqual(hints, want) {
+			t.Errorf("%s: early hints = %q, want %q", resp.Proto, hints, want)
+		}
+		if !http2 && hints != nil {
+			t.Errorf("%s: unexpected early hints %q", resp.Proto, hints)
+		}
+	}
+}
+
+func TestEarlyHintsLoggedStatus(t *testing.T) {
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(io.Discard)
+	var entries entryRecorder
+	SetLogBackend(&entries)
+	defer SetLogBackend(nil)
+
+	m := NewMetrics()
+	hints := EarlyHints([]HintLink{{URL: "/css/site.css", Rel: "preload", As: "style"}})
+	h := Apply(Log, m.Collect, hints)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.ProtoMajor, r.ProtoMinor, r.Proto = 2, 0, "HTTP/2.0"
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(entries) != 1 || entries[0].status != http.StatusOK {
+		t.Errorf("logged %v, want one entry with status 200", entries)
+	}
+	if m.requests[http.StatusOK] != 1 || m.requests[http.StatusEarlyHints] != 0 {
+		t.Errorf("metrics counted %v, want one 200", m.requests)
+	}
+}
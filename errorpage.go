@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// errorPage is an error response body read from a file system on first use.
+type errorPage struct {
+	fsys  fs.FS
+	name  string
+	once  sync.Once
+	body  []byte
+	ctype string
+	err   error
+}
+
+func (p *errorPage) load() ([]byte, string, error) {
+	p.once.Do(func() {
+		p.body, p.err = fs.ReadFile(p.fsys, p.name)
+		p.ctype = mime.TypeByExtension(path.Ext(p.name))
+		if p.ctype == "" {
+			p.ctype = http.DetectContentType(p.body)
+		}
+	})
+	return p.body, p.ctype, p.err
+}
+
+// ErrorPageFS returns a middleware replacing the body of responses whose
+// status code is a key of pages with the contents of the named file in fsys,
+// e.g. {404: "errors/404.html"}. Files are read once and cached in memory.
+func ErrorPageFS(fsys fs.FS, pages map[int]string) Middleware {
+	ep := make(map[int]*errorPage, len(pages))
+	for code, name := range pages {
+		ep[code] = &errorPage{fsys: fsys, name: name}
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(&errorPageWriter{ResponseWriter: w, pages: ep}, r)
+		})
+	}
+}
+
+// errorPageWriter intercepts WriteHeader, substituting the configured error
+// page and discarding the original response body.
+type errorPageWriter struct {
+	http.ResponseWriter
+	pages       map[int]*errorPage
+	wroteHeader bool
+	intercepted bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	// Informational responses, e.g. 103 Early Hints, are followed by the
+	// final response header.
+	w.wroteHeader = code >= 200
+
+	p, ok := w.pages[code]
+	if !ok {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	body, ctype, err := p.load()
+	if err != nil {
+		logger.Printf("error page %d: %v", code, err)
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.intercepted = true
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	h.Set("Content-Type", ctype)
+	w.ResponseWriter.WriteHeader(code)
+	w.ResponseWriter.Write(body)
+}
+
+func (w *errorPageWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.intercepted {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered data to the client, if supported by the underlying
+// ResponseWriter. The body of an error page is written in full by
+// WriteHeader.
+func (w *errorPageWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
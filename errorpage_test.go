@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestErrorPageFS(t *testing.T) {
+	fsys := fstest.MapFS{"errors/404.html": {Data: []byte("<h1>Not here</h1>")}}
+	mw := ErrorPageFS(fsys, map[int]string{http.StatusNotFound: "errors/404.html"})
+
+	tests := []struct {
+		name   string
+		h      http.HandlerFunc
+		status int
+		ctype  string
+		body   string
+	}{
+		{"404 page", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}, http.StatusNotFound, "text/html; charset=utf-8", "<h1>Not here</h1>"},
+		{"handler body", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "teapot", http.StatusTeapot)
+		}, http.StatusTeapot, "text/plain; charset=utf-8", "teapot\n"},
+		{"early hints then 404", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", "</app.css>; rel=preload; as=style")
+			w.WriteHeader(http.StatusEarlyHints)
+			http.NotFound(w, r)
+		}, http.StatusNotFound, "text/html; charset=utf-8", "<h1>Not here</h1>"},
+	}
+	for _, tt := range tests {
+		ts := httptest.NewServer(mw(tt.h))
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			ts.Close()
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		ts.Close()
+		if resp.StatusCode != tt.status || resp.Header.Get("Content-Type") != tt.ctype || string(body) != tt.body {
+			t.Errorf("%s: got %d %q %q, want %d %q %q", tt.name, resp.StatusCode, resp.Header.Get("Content-Type"), body, tt.status, tt.ctype, tt.body)
+		}
+	}
+}
+
+func TestErrorPageFSFlusher(t *testing.T) {
+	mw := ErrorPageFS(fstest.MapFS{}, nil)
+	w := httptest.NewRecorder()
+	mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("Flush: %v", err)
+		}
+	})).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !w.Flushed {
+		t.Errorf("response not flushed through ErrorPageFS")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// faviconMaxAge is the Cache-Control max-age of the favicon. Browsers request
+// it on every page load unless cached.
+const faviconMaxAge = "max-age=604800"
+
+// Favicon returns a middleware serving the image file name for requests to
+// /favicon.ico, with a long cache lifetime. If the file does not exist, the
+// request is passed on, e.g. to a file server answering 404 Not Found.
+func Favicon(name string) Middleware {
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if strings.EqualFold(filepath.Ext(name), ".ico") || ctype == "" {
+		ctype = "image/x-icon"
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/favicon.ico" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+			defer f.Close()
+			fi, err := f.Stat()
+			if err != nil || !fi.Mode().IsRegular() {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", ctype)
+			w.Header().Set("Cache-Control", "public, "+faviconMaxAge)
+			http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+		})
+	}
+}
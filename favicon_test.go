@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFavicon(t *testing.T) {
+	want, err := os.ReadFile("testdata/favicon.ico")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	h := Favicon("testdata/favicon.ico")(http.NotFoundHandler())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/favicon.ico", nil))
+	if w.Code != http.StatusOK || w.Body.String() != string(want) {
+		t.Fatalf("got status %d and %d bytes, want %d and %d bytes", w.Code, w.Body.Len(), http.StatusOK, len(want))
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/x-icon" {
+		t.Errorf("got Content-Type %q, want image/x-icon", got)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "public, "+faviconMaxAge {
+		t.Errorf("got Cache-Control %q, want %q", got, "public, "+faviconMaxAge)
+	}
+
+	h = Favicon(filepath.Join(t.TempDir(), "favicon.ico"))(http.NotFoundHandler())
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/favicon.ico", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("absent favicon: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
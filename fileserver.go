@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// NewAutoFS returns the file system rooted at diskPath if it is an existing
+// directory, or embedded otherwise. This allows a site compiled into the
+// binary to be replaced by its sources on disk during development.
+func NewAutoFS(embedded fs.FS, diskPath string) fs.FS {
+	if diskPath != "" {
+		if fi, err := os.Stat(diskPath); err == nil && fi.IsDir() {
+			return os.DirFS(diskPath)
+		}
+	}
+	return embedded
+}
+
+// RangeHandler returns a handler serving the files of fsys, by request path,
+// with support for range requests as specified by RFC 9110 section 14:
+// responses carry "Accept-Ranges: bytes", and a Range header of one or more
+// byte ranges is answered with 206 Partial Content, as multipart/byteranges
+// for several ranges, or 416 Range Not Satisfiable. Conditional requests,
+// including If-Range, are honoured. A directory is served by its index.html,
+// and dotfiles are hidden as by NoDotfiles. Files must implement io.Seeker or
+// io.ReaderAt, as those of os.DirFS, embed.FS and fstest.MapFS do.
+func RangeHandler(fsys fs.FS) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upath := path.Clean("/" + r.URL.Path)
+		if hasDotfile(upath) {
+			http.NotFound(w, r)
+			return
+		}
+		name := strings.TrimPrefix(upath, "/")
+		if name == "" {
+			name = "."
+		}
+		f, fi, err := openRangeFile(fsys, name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		var content io.ReadSeeker
+		switch f := f.(type) {
+		case io.ReadSeeker:
+			content = f
+		case io.ReaderAt:
+			content = io.NewSectionReader(f, 0, fi.Size())
+		default:
+			logger.Printf("range: %s: file does not support seeking", name)
+			http.Error(w, "Error reading file", http.StatusInternalServerError)
+			return
+		}
+		// ServeContent parses and validates the Range header, and writes
+		// Content-Range and Accept-Ranges.
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), content)
+	})
+}
+
+// openRangeFile opens the named regular file in fsys, or the index.html of
+// the named directory.
+func openRangeFile(fsys fs.FS, name string) (fs.File, fs.FileInfo, error) {
+	for {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		if fi.Mode().IsRegular() {
+			return f, fi, nil
+		}
+		f.Close()
+		if !fi.IsDir() || path.Base(name) == "index.html" {
+			return nil, nil, fs.ErrNotExist
+		}
+		name = path.Join(name, "index.html")
+	}
+}
+
+// DirListData is passed to directory listing templates.
+type DirListData struct {
+	Path       string        // Directory path, with trailing slash
+	Entries    []fs.DirEntry // Directory entries, sorted by name
+	ParentPath string        // Parent directory path, or empty at the root
+}
+
+// FileServerWithTemplate returns a handler serving root like http.FileServer,
+// but rendering directory listings with tmpl. Directories containing an
+// index.html are served as usual. If tmpl is nil, listings are rendered by
+// http.FileServer.
+func FileServerWithTemplate(root http.FileSystem, tmpl *template.Template) http.Handler {
+	fsrv := http.FileServer(root)
+	if tmpl == nil {
+		return fsrv
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upath := r.URL.Path
+		if !strings.HasPrefix(upath, "/") {
+			upath = "/" + upath
+		}
+		if !strings.HasSuffix(upath, "/") {
+			fsrv.ServeHTTP(w, r)
+			return
+		}
+		upath = path.Clean(upath)
+
+		if f, err := root.Open(path.Join(upath, "index.html")); err == nil {
+			f.Close()
+			fsrv.ServeHTTP(w, r)
+			return
+		}
+
+		d, err := root.Open(upath)
+		if err != nil {
+			fsrv.ServeHTTP(w, r)
+			return
+		}
+		defer d.Close()
+		fi, err := d.Stat()
+		if err != nil || !fi.IsDir() {
+			fsrv.ServeHTTP(w, r)
+			return
+		}
+		infos, err := d.Readdir(-1)
+		if err != nil {
+			http.Error(w, "Error reading directory", http.StatusInternalServerError)
+			return
+		}
+
+		data := DirListData{
+			Path:    strings.TrimSuffix(upath, "/") + "/",
+			Entries: make([]fs.DirEntry, len(infos)),
+		}
+		for i, fi := range infos {
+			data.Entries[i] = fs.FileInfoToDirEntry(fi)
+		}
+		sort.Slice(data.Entries, func(i, j int) bool {
+			return data.Entries[i].Name() < data.Entries[j].Name()
+		})
+		if upath != "/" {
+			data.ParentPath = strings.TrimSuffix(path.Dir(upath), "/") + "/"
+		}
+
+		// The listing is rendered before any of it is written, so that a
+		// failing template is reported with a 500 response.
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			logger.Printf("directory listing: %v", err)
+			http.Error(w, "Error listing directory", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		buf.WriteTo(w)
+	})
+}
+
+// NoDotfiles returns root with files and directories whose names begin with
+// '.', such as .git and .env, hidden: opening a path with such a component
+// fails with fs.ErrNotExist, and they are omitted from directory listings.
+func NoDotfiles(root http.FileSystem) http.FileSystem {
+	return noDotfilesFS{root}
+}
+
+type noDotfilesFS struct {
+	root http.FileSystem
+}
+
+func (fsys noDotfilesFS) Open(name string) (http.File, error) {
+	if hasDotfile(name) {
+		return nil, fs.ErrNotExist
+	}
+	f, err := fsys.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return noDotfilesFile{f}, nil
+}
+
+// hasDotfile reports whether an element of the slash-separated path name
+// begins with '.'.
+func hasDotfile(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if strings.HasPrefix(elem, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// noDotfilesFile is a file whose directory entries exclude dotfiles.
+type noDotfilesFile struct {
+	http.File
+}
+
+// Readdir reads as the underlying file does, but if n > 0 it continues
+// reading until at least one visible entry is found, as http.File requires,
+// or the end of the directory.
+func (f noDotfilesFile) Readdir(n int) ([]fs.FileInfo, error) {
+	for {
+		infos, err := f.File.Readdir(n)
+		visible := infos[:0]
+		for _, fi := range infos {
+			if !strings.HasPrefix(fi.Name(), ".") {
+				visible = append(visible, fi)
+			}
+		}
+		if len(visible) > 0 || err != nil || n <= 0 {
+			return visible, err
+		}
+	}
+}
+
+// TrailingSlash returns a middleware canonicalizing request paths for files in
+// root: directories are redirected to the path with a trailing slash and, if
+// stripFiles is set, files requested with a trailing slash to the path without
+// it. Redirects are permanent, so that clients and caches see one URL for each
+// resource.
+func TrailingSlash(root http.FileSystem, stripFiles bool) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upath := r.URL.Path
+			if upath == "" || upath == "/" || (r.Method != "GET" && r.Method != "HEAD") {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			var target string
+			hasSlash := strings.HasSuffix(upath, "/")
+			if fi, err := statFile(root, path.Clean(upath)); err == nil {
+				switch {
+				case fi.IsDir() && !hasSlash:
+					target = upath + "/"
+				case !fi.IsDir() && hasSlash && stripFiles:
+					target = strings.TrimSuffix(upath, "/")
+				}
+			}
+			if target == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			u := *r.URL
+			u.Path = target
+			u.RawPath = ""
+			http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+		})
+	}
+}
+
+// NormalisePath returns a middleware permanently redirecting requests whose
+// path is not in canonical form, e.g. "/a/../b" or "//b", to the path
+// cleaned by path.Clean, beginning with '/' and keeping any trailing slash.
+// The query is preserved.
+func NormalisePath() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upath := r.URL.Path
+			clean := path.Clean("/" + upath)
+			if strings.HasSuffix(upath, "/") && clean != "/" {
+				clean += "/"
+			}
+			if clean == upath {
+				h.ServeHTTP(w, r)
+				return
+			}
+			u := *r.URL
+			u.Path = clean
+			u.RawPath = ""
+			http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+		})
+	}
+}
+
+func statFile(root http.FileSystem, name string) (fs.FileInfo, error) {
+	f, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// DefaultContentTypes are content types, by file extension, which
+// mime.TypeByExtension may get wrong or not know.
+var DefaultContentTypes = map[string]string{
+	".avif":        "image/avif",
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+}
+
+// ContentTypes returns a middleware setting the Content-Type of responses for
+// files with an extension in types, such as ".wasm", in place of the type
+// guessed by http.FileServer. Extensions are matched case-insensitively.
+func ContentTypes(types map[string]string) Middleware {
+	lower := make(map[string]string, len(types))
+	for ext, ctype := range types {
+		lower[strings.ToLower(ext)] = ctype
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ctype, ok := lower[strings.ToLower(path.Ext(r.URL.Path))]; ok {
+				w.Header().Set("Content-Type", ctype)
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Mount serves the directory Dir beneath the URL path Prefix.
+type Mount struct {
+	Prefix string // URL path prefix, e.g. "/assets"
+	Dir    string // File system directory
+}
+
+// ParseMount parses a mount of the form "/prefix=dir".
+func ParseMount(s string) (Mount, error) {
+	prefix, dir, ok := strings.Cut(s, "=")
+	prefix = strings.TrimSuffix(prefix, "/")
+	if !ok || !strings.HasPrefix(prefix, "/") || dir == "" {
+		return Mount{}, fmt.Errorf("invalid mount %q (want /prefix=dir)", s)
+	}
+	return Mount{Prefix: prefix, Dir: dir}, nil
+}
+
+// registerMounts registers a file server on mux for each of mounts.
+func registerMounts(mux *http.ServeMux, mounts []Mount) {
+	for _, m := range mounts {
+		mux.Handle(m.Prefix+"/", http.StripPrefix(m.Prefix, http.FileServer(NoDotfiles(http.Dir(m.Dir)))))
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTrailingSlash(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "about"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	for _, name := range []string{"about/index.html", "page.html"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("ok"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	root := http.Dir(dir)
+
+	tests := []struct {
+		path       string
+		stripFiles bool
+		status     int
+		location   string
+	}{
+		{"/about", false, http.StatusMovedPermanently, "/about/"},
+		{"/about?lang=en", false, http.StatusMovedPermanently, "/about/?lang=en"},
+		{"/about/", false, http.StatusOK, ""},
+		{"/page.html", true, http.StatusOK, ""},
+		{"/page.html/", true, http.StatusMovedPermanently, "/page.html"},
+		{"/missing", true, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		h := TrailingSlash(root, tt.stripFiles)(http.StripPrefix("/", http.FileServer(root)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: got Location %q, want %q", tt.path, got, tt.location)
+		}
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"app.wasm", "site.webmanifest", "MANIFEST.WEBMANIFEST", "page.html"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	h := ContentTypes(DefaultContentTypes)(http.FileServer(http.Dir(dir)))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/app.wasm", "application/wasm"},
+		{"/site.webmanifest", "application/manifest+json"},
+		{"/MANIFEST.WEBMANIFEST", "application/manifest+json"},
+		{"/page.html", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if got := w.Header().Get("Content-Type"); got != tt.want {
+			t.Errorf("%s: got Content-Type %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNewAutoFS(t *testing.T) {
+	embedded := fstest.MapFS{"index.html": {Data: []byte("embedded")}}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("disk"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tests := []struct {
+		diskPath string
+		want     string
+	}{
+		{dir, "disk"},
+		{"", "embedded"},
+		{filepath.Join(dir, "missing"), "embedded"},
+	}
+	for _, tt := range tests {
+		b, err := fs.ReadFile(NewAutoFS(embedded, tt.diskPath), "index.html")
+		if err != nil || string(b) != tt.want {
+			t.Errorf("diskPath %q: got %q, %v, want %q", tt.diskPath, b, err, tt.want)
+		}
+	}
+}
+
+func TestMounts(t *testing.T) {
+	public, docs := t.TempDir(), t.TempDir()
+	if err := os.WriteFile(filepath.Join(public, "app.css"), []byte("css"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(docs, "guide.txt"), []byte("guide"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var mounts []Mount
+	for _, s := range []string{"/assets=" + public, "/docs/=" + docs} {
+		m, err := ParseMount(s)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		mounts = append(mounts, m)
+	}
+	mux := http.NewServeMux()
+	registerMounts(mux, mounts)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/assets/app.css", http.StatusOK, "css"},
+		{"/docs/guide.txt", http.StatusOK, "guide"},
+		{"/assets/guide.txt", http.StatusNotFound, ""},
+		{"/docs/app.css", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status || (tt.status == http.StatusOK && w.Body.String() != tt.body) {
+			t.Errorf("%s: got %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.status, tt.body)
+		}
+	}
+
+	for _, s := range []string{"assets=dir", "/assets", "/assets="} {
+		if _, err := ParseMount(s); err == nil {
+			t.Errorf("ParseMount(%q): expected error", s)
+		}
+	}
+}
+
+func TestNormalisePath(t *testing.T) {
+	h := NormalisePath()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		path, location string
+	}{
+		{"/about/../contact", "/contact"},
+		{"//css//site.css?v=2", "/css/site.css?v=2"},
+		{"/a/./b/", "/a/b/"},
+		{"/a/b/..", "/a"},
+		{"/contact", ""},
+		{"/docs/", ""},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path, r.URL.RawQuery, _ = strings.Cut(tt.path, "?")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if tt.location == "" {
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: got status %d, want 200", tt.path, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d %q, want 301 %q", tt.path, w.Code, w.Header().Get("Location"), tt.location)
+		}
+	}
+}
+
+func TestNoDotfiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{".git", "a/.hidden"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	for _, name := range []string{".git/config", ".env", "a/.hidden/x.txt", "a/page.html"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("ok"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	h := http.FileServer(NoDotfiles(http.Dir(dir)))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/.git/config", http.StatusNotFound},
+		{"/.env", http.StatusNotFound},
+		{"/a/.hidden/x.txt", http.StatusNotFound},
+		{"/a/page.html", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if body := w.Body.String(); strings.Contains(body, ".git") || strings.Contains(body, ".env") || !strings.Contains(body, "a/") {
+		t.Errorf("listing: got %q, want dotfiles omitted", body)
+	}
+}
+
+func TestNoDotfilesReaddir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"hidden/.a", "hidden/.b", "hidden/.c", "mixed/.a", "mixed/.b", "mixed/page.html"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("ok"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	fsys := NoDotfiles(http.Dir(dir))
+
+	readdir := func(name string) ([]string, error) {
+		t.Helper()
+		f, err := fsys.Open(name)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer f.Close()
+		var names []string
+		for {
+			infos, err := f.Readdir(1)
+			if len(infos) == 0 && err == nil {
+				return names, fmt.Errorf("%s: Readdir(1) returned no entries and no error", name)
+			}
+			for _, fi := range infos {
+				names = append(names, fi.Name())
+			}
+			if err == io.EOF {
+				return names, nil
+			}
+			if err != nil {
+				return names, err
+			}
+		}
+	}
+
+	for dir, want := range map[string][]string{"/hidden": nil, "/mixed": {"page.html"}} {
+		got, err := readdir(dir)
+		if err != nil {
+			t.Errorf("%v", err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: read %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestFileServerWithTemplate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/b.html", "a/.env", "a/c/d.html", "site/index.html", ".git/config"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	tmpl := template.Must(template.New("list").Parse(
+		`<h1>{{.Path}}</h1>{{if .ParentPath}}<a href="{{.ParentPath}}">up</a>{{end}}{{range .Entries}}<li>{{.Name}}</li>{{end}}`))
+	h := FileServerWithTemplate(NoDotfiles(http.Dir(dir)), tmpl)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/a/", http.StatusOK, `<h1>/a/</h1><a href="/">up</a><li>b.html</li><li>c</li>`},
+		{"/a/c/", http.StatusOK, `<h1>/a/c/</h1><a href="/a/">up</a><li>d.html</li>`},
+		{"/a/c/../", http.StatusOK, `<h1>/a/</h1><a href="/">up</a><li>b.html</li><li>c</li>`},
+		{"/", http.StatusOK, `<h1>/</h1><li>a</li><li>site</li>`},
+		{"/site/", http.StatusOK, "site/index.html"},
+		{"/a/b.html", http.StatusOK, "a/b.html"},
+		{"/.git/", http.StatusNotFound, ""},
+		{"/a/.env", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = tt.path
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.path, w.Body.String(), tt.body)
+		}
+	}
+
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(io.Discard)
+	for name, text := range map[string]string{
+		"exec":   `<h1>{{.Path}}</h1>{{.Missing}}`,
+		"escape": `<a href="{{.Path}}`,
+	} {
+		h := FileServerWithTemplate(http.Dir(dir), template.Must(template.New(name).Parse(text)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/a/", nil))
+		if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "<h1>") {
+			t.Errorf("%s error: got %d %q, want 500 without the partial listing", name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRangeHandler(t *testing.T) {
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte('a' + i%26)
+	}
+	h := RangeHandler(fstest.MapFS{
+		"file.bin":        {Data: data},
+		"docs/index.html": {Data: []byte("index")},
+	})
+
+	tests := []struct {
+		path, rng    string
+		status       int
+		contentRange string
+		body         string
+	}{
+		{"/file.bin", "", http.StatusOK, "", string(data)},
+		{"/file.bin", "bytes=0-9", http.StatusPartialContent, "bytes 0-9/100", string(data[:10])},
+		{"/file.bin", "bytes=-10", http.StatusPartialContent, "bytes 90-99/100", string(data[90:])},
+		{"/file.bin", "bytes=95-", http.StatusPartialContent, "bytes 95-99/100", string(data[95:])},
+		{"/file.bin", "bytes=200-", http.StatusRequestedRangeNotSatisfiable, "bytes */100", ""},
+		{"/file.bin", "bytes=9-0", http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"/docs/", "", http.StatusOK, "", "index"},
+		{"/missing", "", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s %q: got status %d, want %d", tt.path, tt.rng, w.Code, tt.status)
+			continue
+		}
+		if got := w.Header().Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s %q: got Content-Range %q, want %q", tt.path, tt.rng, got, tt.contentRange)
+		}
+		if tt.status == http.StatusOK || tt.status == http.StatusPartialContent {
+			if got := w.Header().Get("Accept-Ranges"); tt.path == "/file.bin" && got != "bytes" {
+				t.Errorf("%s: got Accept-Ranges %q, want bytes", tt.path, got)
+			}
+			if w.Body.String() != tt.body {
+				t.Errorf("%s %q: got body %q, want %q", tt.path, tt.rng, w.Body.String(), tt.body)
+			}
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/file.bin", nil)
+	r.Header.Set("Range", "bytes=0-1,10-11")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if ctype := w.Header().Get("Content-Type"); w.Code != http.StatusPartialContent || !strings.HasPrefix(ctype, "multipart/byteranges") {
+		t.Errorf("multiple ranges: got %d %q, want 206 multipart/byteranges", w.Code, ctype)
+	}
+	for _, part := range []string{"Content-Range: bytes 0-1/100\r\n", "\r\n\r\nab\r\n", "Content-Range: bytes 10-11/100\r\n", "\r\n\r\nkl\r\n"} {
+		if !strings.Contains(w.Body.String(), part) {
+			t.Errorf("multiple ranges: body missing %q", part)
+		}
+	}
+}
+
+// readerAtFS is a file system whose files implement io.ReaderAt but not
+// io.Seeker, or neither if noReadAt is set.
+type readerAtFS struct {
+	fstest.MapFS
+	noReadAt bool
+}
+
+type readerAtFile struct {
+	fs.File
+	io.ReaderAt
+}
+
+type plainFile struct{ fs.File }
+
+func (fsys readerAtFS) Open(name string) (fs.File, error) {
+	f, err := fsys.MapFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if fsys.noReadAt {
+		return plainFile{f}, nil
+	}
+	return readerAtFile{f, f.(io.ReaderAt)}, nil
+}
+
+func TestRangeHandlerFiles(t *testing.T) {
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(io.Discard)
+
+	files := fstest.MapFS{
+		"file.txt":      {Data: []byte("0123456789")},
+		".env":          {Data: []byte("SECRET=1")},
+		".git/config":   {Data: []byte("[core]")},
+		"a/.secret.txt": {Data: []byte("secret")},
+	}
+	tests := []struct {
+		name   string
+		fsys   fs.FS
+		path   string
+		status int
+		body   string
+	}{
+		{"seeker", files, "/file.txt", http.StatusPartialContent, "234"},
+		{"reader at", readerAtFS{MapFS: files}, "/file.txt", http.StatusPartialContent, "234"},
+		{"neither", readerAtFS{MapFS: files, noReadAt: true}, "/file.txt", http.StatusInternalServerError, ""},
+		{"dotfile", files, "/.env", http.StatusNotFound, ""},
+		{"dot directory", files, "/.git/config", http.StatusNotFound, ""},
+		{"nested dotfile", files, "/a/.secret.txt", http.StatusNotFound, ""},
+		{"cleaned dotfile", files, "/a/../.env", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = tt.path
+		r.Header.Set("Range", "bytes=2-4")
+		w := httptest.NewRecorder()
+		RangeHandler(tt.fsys).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.name, w.Body.String(), tt.body)
+		}
+	}
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"mime"
+	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -31,77 +35,408 @@ var csp = map[string][]string{
 var DefaultCSP = "default-src 'none';"
 
 func init() {
+	DefaultCSP = buildCSP(csp)
+	currentPolicy.Store(&headerPolicy{
+		csp:            DefaultCSP,
+		cspHeader:      []string{DefaultCSP},
+		allowedMethods: DefaultAllowedMethods,
+	})
+}
+
+// buildCSP returns the directives in Content-Security-Policy header syntax.
+func buildCSP(directives map[string][]string) string {
 	var c []string
-	for k := range csp {
-		c = append(c, fmt.Sprintf("%s %s", k, strings.Join(csp[k], " ")))
+	for k := range directives {
+		c = append(c, fmt.Sprintf("%s %s", k, strings.Join(directives[k], " ")))
 	}
 	sort.Strings(c)
-	DefaultCSP = strings.Join(c, ";")
+	return strings.Join(c, ";")
+}
+
+// headerPolicy holds the header settings which may be replaced at runtime by
+// ReloadConfig.
+type headerPolicy struct {
+	csp            string
+	cspHeader      []string // csp, as a header value
+	allowedMethods []string
+}
+
+var currentPolicy atomic.Pointer[headerPolicy]
+
+// setHeaderPolicy replaces the CSP directives and allowed methods used by
+// SecureHeaders and AcceptHeaders. Nil arguments select the defaults.
+func setHeaderPolicy(directives map[string][]string, methods []string) {
+	p := &headerPolicy{
+		csp:            DefaultCSP,
+		allowedMethods: DefaultAllowedMethods,
+	}
+	if directives != nil {
+		p.csp = buildCSP(directives)
+	}
+	p.cspHeader = []string{p.csp}
+	if methods != nil {
+		p.allowedMethods = methods
+	}
+	currentPolicy.Store(p)
+}
+
+// trustedProxies are the CIDR ranges of the reverse proxies whose
+// X-Forwarded-Proto header is trusted by SecureHeaders.
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the CIDR ranges, such as "10.0.0.0/8", of the
+// reverse proxies terminating TLS in front of the server. Requests from them
+// with "X-Forwarded-Proto: https" are not redirected to HTTPS. It must be
+// called before the server is started.
+func SetTrustedProxies(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	trustedProxies = nets
+	return nil
+}
+
+// secureRequest reports whether r was received over TLS, directly, through a
+// local reverse proxy on a Unix domain socket, or through a trusted proxy.
+func secureRequest(r *http.Request) bool {
+	if r.URL.Scheme == "http" {
+		return false
+	}
+	if r.TLS != nil || unixSocket(r) {
+		return true
+	}
+	return r.Header.Get("X-Forwarded-Proto") == "https" && remoteIn(r, trustedProxies)
+}
+
+// PermissionsPolicy selects the browser features a document may use. Features
+// are disabled unless their field is set, in which case they are restricted
+// to the document's own origin.
+type PermissionsPolicy struct {
+	Accelerometer    bool
+	Autoplay         bool
+	BrowsingTopics   bool
+	Camera           bool
+	DisplayCapture   bool
+	Fullscreen       bool
+	Geolocation      bool
+	Gyroscope        bool
+	InterestCohort   bool
+	Magnetometer     bool
+	Microphone       bool
+	MIDI             bool
+	Payment          bool
+	PictureInPicture bool
+	ScreenWakeLock   bool
+	USB              bool
+}
+
+// DefaultPermissionsPolicy disables all features.
+var DefaultPermissionsPolicy = PermissionsPolicy{}
+
+// Build returns the policy in Permissions-Policy header syntax.
+func (p PermissionsPolicy) Build() string {
+	features := []struct {
+		name  string
+		allow bool
+	}{
+		{"accelerometer", p.Accelerometer},
+		{"autoplay", p.Autoplay},
+		{"browsing-topics", p.BrowsingTopics},
+		{"camera", p.Camera},
+		{"display-capture", p.DisplayCapture},
+		{"fullscreen", p.Fullscreen},
+		{"geolocation", p.Geolocation},
+		{"gyroscope", p.Gyroscope},
+		{"interest-cohort", p.InterestCohort},
+		{"magnetometer", p.Magnetometer},
+		{"microphone", p.Microphone},
+		{"midi", p.MIDI},
+		{"payment", p.Payment},
+		{"picture-in-picture", p.PictureInPicture},
+		{"screen-wake-lock", p.ScreenWakeLock},
+		{"usb", p.USB},
+	}
+
+	d := make([]string, len(features))
+	for i, f := range features {
+		allowlist := "()"
+		if f.allow {
+			allowlist = "(self)"
+		}
+		d[i] = f.name + "=" + allowlist
+	}
+	return strings.Join(d, ", ")
+}
+
+// HSTSOptions configures the Strict-Transport-Security header. A MaxAge of
+// zero omits the header, e.g. for development instances.
+type HSTSOptions struct {
+	MaxAge            int // Seconds
+	IncludeSubDomains bool
+	Preload           bool
 }
 
-var hostList = map[string]bool{
-	"blog.bwsd.net": true,
-	"bwsd.net":      true,
-	"www.bwsd.net":  true,
+// DefaultHSTSOptions meets the requirements for inclusion in the HSTS preload
+// list.
+var DefaultHSTSOptions = HSTSOptions{
+	MaxAge:            63072000,
+	IncludeSubDomains: true,
+	Preload:           true,
+}
+
+// String returns the options in Strict-Transport-Security header syntax.
+func (o HSTSOptions) String() string {
+	v := "max-age=" + strconv.Itoa(o.MaxAge)
+	if o.IncludeSubDomains {
+		v += "; includeSubDomains"
+	}
+	if o.Preload {
+		v += "; preload"
+	}
+	return v
+}
+
+type secureHeadersOptions struct {
+	permissions PermissionsPolicy
+	hsts        HSTSOptions
+}
+
+// SecureHeadersOption configures the policies set by SecureHeaders.
+type SecureHeadersOption func(*secureHeadersOptions)
+
+// WithPermissionsPolicy sets the Permissions-Policy used by SecureHeaders.
+func WithPermissionsPolicy(p PermissionsPolicy) SecureHeadersOption {
+	return func(o *secureHeadersOptions) {
+		o.permissions = p
+	}
+}
+
+// WithHSTS sets the Strict-Transport-Security options used by SecureHeaders.
+func WithHSTS(h HSTSOptions) SecureHeadersOption {
+	return func(o *secureHeadersOptions) {
+		o.hsts = h
+	}
+}
+
+// precomputedHeaders holds the constant headers set by SecureHeaders, built
+// once with canonical keys so that they may be assigned to the header map of
+// each response directly.
+type precomputedHeaders struct {
+	keys   []string
+	values [][]string
+}
+
+func newPrecomputedHeaders(o *secureHeadersOptions) *precomputedHeaders {
+	p := &precomputedHeaders{}
+	add := func(key, value string) {
+		p.keys = append(p.keys, http.CanonicalHeaderKey(key))
+		p.values = append(p.values, []string{value})
+	}
+	// TLDs pre-registered on the HSTS preload list can omit this header.
+	if o.hsts.MaxAge > 0 {
+		add("Strict-Transport-Security", o.hsts.String())
+	}
+	// Obsoleted by CSP frame-ancesors directive.
+	add("X-Frame-Options", "Deny")
+	// Disables FloC and Topics cohort calculations, amongst others, unless
+	// explicitly allowed.
+	add("Permissions-Policy", o.permissions.Build())
+	add("X-Content-Type-Options", "nosniff")
+	add("Referrer-Policy", "same-origin")
+	return p
+}
+
+// set sets the headers in h. The values are shared between responses, and
+// have no spare capacity, so that appending to them copies.
+func (p *precomputedHeaders) set(h http.Header) {
+	for i, k := range p.keys {
+		h[k] = p.values[i]
+	}
 }
 
 // SecureHeaders returns a handler with security options and policies appended to
 // response headers.
-func SecureHeaders() Middleware {
+func SecureHeaders(opts ...SecureHeadersOption) Middleware {
+	o := &secureHeadersOptions{
+		permissions: DefaultPermissionsPolicy,
+		hsts:        DefaultHSTSOptions,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	headers := newPrecomputedHeaders(o)
+
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			var host string
-			host = strings.ToLower(r.Host)
-			if ok := hostList[host]; !ok {
-				host = "bwsd.net"
-			}
-			if r.TLS == nil || r.URL.Scheme == "http" {
-				r.URL.Scheme = "https"
-				http.Redirect(w, r, r.URL.String(), http.StatusMovedPermanently)
+			if !secureRequest(r) {
+				// The target is built from the request host and the request
+				// URI as sent, as the URL of a server request has no host.
+				// Fragments are not sent by clients.
+				host := requestHost(r)
+				if host == "" {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+				uri := r.RequestURI
+				if uri == "" {
+					uri = r.URL.RequestURI()
+				}
+				http.Redirect(w, r, "https://"+host+uri, http.StatusMovedPermanently)
 				return
 			}
 
-			// TLDs pre-registered on the HSTS preload list can omit this header.
-			w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains; preload")
+			hdr := w.Header()
+			headers.set(hdr)
+			hdr["Content-Security-Policy"] = currentPolicy.Load().cspHeader
 
-			w.Header().Set("Content-Security-Policy", DefaultCSP)
-
-			// Obsoleted by CSP frame-ancesors directive.
-			w.Header().Set("X-Frame-Options", "Deny")
+			h.ServeHTTP(w, r)
+		})
+	}
+}
 
-			// Opt out of Google's FloC cohort calculations
-			w.Header().Set("Permissions-Policy", "interest-cohort=()")
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("Referrer-Policy", "same-origin")
+// ServerHeader returns a middleware setting the Server header of responses
+// to value, replacing any set by the handler, or removing it if value is
+// empty, so that the software serving the site is not advertised.
+func ServerHeader(value string) Middleware {
+	var v []string
+	if value != "" {
+		v = []string{value}
+	}
 
-			h.ServeHTTP(w, r)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(&serverHeaderWriter{ResponseWriter: w, value: v}, r)
 		})
 	}
 }
 
-const maxURILen = 512
+// serverHeaderWriter sets or removes the Server header before the response
+// header is written.
+type serverHeaderWriter struct {
+	http.ResponseWriter
+	value       []string
+	wroteHeader bool
+}
+
+func (w *serverHeaderWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		if w.value != nil {
+			w.Header()["Server"] = w.value
+		} else {
+			delete(w.Header(), "Server")
+		}
+		// Informational responses, e.g. 103 Early Hints, are followed by
+		// the final response header.
+		w.wroteHeader = code >= 200
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *serverHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends buffered data to the client, if supported by the underlying
+// ResponseWriter.
+func (w *serverHeaderWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (w *serverHeaderWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// unixSocket reports whether r was received on a Unix domain socket, in which
+// case TLS is assumed to have been terminated by a local reverse proxy.
+func unixSocket(r *http.Request) bool {
+	a, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && a.Network() == "unix"
+}
+
+// MaxURILen is the maximum length, in bytes, of a request URI accepted by
+// AcceptHeaders. Longer URIs are rejected with 414 Request-URI Too Long.
+var MaxURILen = 512
+
+// MaxHeaderBytes is the maximum size, in bytes, of the request header fields
+// accepted by AcceptHeaders, counted as sent on the wire, and the
+// MaxHeaderBytes of the site's servers. net/http itself reads up to 4096
+// bytes more before responding 431 Request Header Fields Too Large and closing
+// the connection, without calling a handler; AcceptHeaders rejects requests
+// within that margin with 431 too, and logs them.
+var MaxHeaderBytes = http.DefaultMaxHeaderBytes >> 8
 
 var DefaultAllowedMethods = []string{"GET", "HEAD", "OPTIONS"}
 
+// AcceptOptions configures AcceptHeadersWith.
+type AcceptOptions struct {
+	// MaxURILen is the maximum request URI length. Zero selects MaxURILen.
+	MaxURILen int
+
+	// MaxHeaderBytes is the maximum size of the request header fields.
+	// Zero selects MaxHeaderBytes.
+	MaxHeaderBytes int
+
+	// AllowedMethods are the request methods accepted. If empty, the
+	// current allowed methods, by default DefaultAllowedMethods, are used.
+	AllowedMethods []string
+}
+
 // AcceptHeaders returns a handler with a list of acceptable methods, returning
 // a HTTP 4xx error response when request method is disallowed or exceeds length
-// restrictions.
+// restrictions. If no methods are given, the current allowed methods, by
+// default DefaultAllowedMethods, are used.
+//
+// Deprecated: Use AcceptHeadersWith.
 func AcceptHeaders(m ...string) Middleware {
+	return AcceptHeadersWith(AcceptOptions{AllowedMethods: m})
+}
+
+// AcceptHeadersWith returns a handler accepting requests as configured by
+// opts, returning a HTTP 4xx error response when the request method is
+// disallowed or the request URI or header fields are too long.
+func AcceptHeadersWith(opts AcceptOptions) Middleware {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var status int
 
-			if len(r.URL.String()) >= maxURILen {
+			maxLen := opts.MaxURILen
+			if maxLen == 0 {
+				maxLen = MaxURILen
+			}
+			if len(r.URL.String()) > maxLen {
 				status = http.StatusRequestURITooLong
 				http.Error(w, http.StatusText(http.StatusRequestURITooLong), status)
 				return
 			}
 
-			if len(m) == 0 {
-				m = DefaultAllowedMethods
+			maxHeader := opts.MaxHeaderBytes
+			if maxHeader == 0 {
+				maxHeader = MaxHeaderBytes
+			}
+			if n := headerSize(r.Header); n > maxHeader {
+				logger.Printf("%s: request header too large: %d bytes (max %d)", r.RemoteAddr, n, maxHeader)
+				status = http.StatusRequestHeaderFieldsTooLarge
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+
+			methods := opts.AllowedMethods
+			if len(methods) == 0 {
+				methods = currentPolicy.Load().allowedMethods
 			}
 
-			for _, am := range DefaultAllowedMethods {
+			for _, am := range methods {
 				if r.Method == am {
 					h.ServeHTTP(w, r)
 					return
@@ -113,3 +448,42 @@ func AcceptHeaders(m ...string) Middleware {
 		})
 	}
 }
+
+// headerSize returns the size of the header fields in h as sent in HTTP/1.1,
+// each as "Key: value\r\n".
+func headerSize(h http.Header) int {
+	n := 0
+	for k, vs := range h {
+		for _, v := range vs {
+			n += len(k) + len(v) + 4
+		}
+	}
+	return n
+}
+
+// AllowContentTypes returns a middleware responding 415 Unsupported Media Type
+// to requests with a body whose Content-Type, ignoring parameters such as
+// charset, is not one of types. GET and HEAD requests, requests without a
+// body, and all requests if types is empty, are passed through.
+func AllowContentTypes(types ...string) Middleware {
+	allowed := make(map[string]bool, len(types))
+	for _, t := range types {
+		allowed[strings.ToLower(t)] = true
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// ContentLength is -1 for bodies of unknown length.
+			if len(allowed) == 0 || r.Method == "GET" || r.Method == "HEAD" || r.ContentLength == 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+			mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || !allowed[mt] {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -33,3 +38,284 @@ func TestSecHeaders(t *testing.T) {
 		}
 	}
 }
+
+func TestPermissionsPolicy(t *testing.T) {
+	tests := []struct {
+		p    PermissionsPolicy
+		want []string
+	}{
+		{DefaultPermissionsPolicy, []string{"camera=()", "interest-cohort=()", "usb=()"}},
+		{PermissionsPolicy{Camera: true, USB: true}, []string{"camera=(self)", "microphone=()", "usb=(self)"}},
+	}
+
+	for _, tt := range tests {
+		got := tt.p.Build()
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("Build() = %q, missing %q", got, w)
+			}
+		}
+	}
+}
+
+func TestSecHeadersPermissionsPolicy(t *testing.T) {
+	p := PermissionsPolicy{Geolocation: true}
+	shm := SecureHeaders(WithPermissionsPolicy(p))
+	ts := httptest.NewTLSServer(shm(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {})),
+	)
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.Header.Get("permissions-policy"), p.Build(); got != want {
+		t.Errorf("permissions-policy = %q, want %q", got, want)
+	}
+}
+
+func TestSecHeadersHSTS(t *testing.T) {
+	tests := []struct {
+		opts HSTSOptions
+		want string
+	}{
+		{DefaultHSTSOptions, "max-age=63072000; includeSubDomains; preload"},
+		{HSTSOptions{MaxAge: 300}, "max-age=300"},
+		{HSTSOptions{}, ""},
+	}
+
+	for _, tt := range tests {
+		shm := SecureHeaders(WithHSTS(tt.opts))
+		ts := httptest.NewTLSServer(shm(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {})),
+		)
+
+		resp, err := ts.Client().Get(ts.URL)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		resp.Body.Close()
+		ts.Close()
+
+		if got := resp.Header.Get("strict-transport-security"); got != tt.want {
+			t.Errorf("strict-transport-security = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestAcceptHeadersURILen(t *testing.T) {
+	tests := []struct {
+		max  int
+		n    int
+		want int
+	}{
+		{0, MaxURILen - 1, http.StatusOK},
+		{0, MaxURILen, http.StatusOK},
+		{0, MaxURILen + 1, http.StatusRequestURITooLong},
+		{2048, 2048, http.StatusOK},
+		{2048, 2049, http.StatusRequestURITooLong},
+	}
+	for _, tt := range tests {
+		h := AcceptHeadersWith(AcceptOptions{MaxURILen: tt.max})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		uri := "/" + strings.Repeat("a", tt.n-1)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", uri, nil))
+		if w.Code != tt.want {
+			t.Errorf("max %d, URI length %d: got status %d, want %d", tt.max, tt.n, w.Code, tt.want)
+		}
+	}
+}
+
+// BenchmarkSecureHeaders compares setting the security headers through
+// Header.Set, as SecureHeaders did, with assigning the precomputed values.
+func BenchmarkSecureHeaders(b *testing.B) {
+	o := &secureHeadersOptions{permissions: DefaultPermissionsPolicy, hsts: DefaultHSTSOptions}
+	b.Run("Set", func(b *testing.B) {
+		hsts, permissions := o.hsts.String(), o.permissions.Build()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h := make(http.Header)
+			h.Set("Strict-Transport-Security", hsts)
+			h.Set("Content-Security-Policy", currentPolicy.Load().csp)
+			h.Set("X-Frame-Options", "Deny")
+			h.Set("Permissions-Policy", permissions)
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("Referrer-Policy", "same-origin")
+		}
+	})
+	b.Run("Precomputed", func(b *testing.B) {
+		p := newPrecomputedHeaders(o)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h := make(http.Header)
+			p.set(h)
+			h["Content-Security-Policy"] = currentPolicy.Load().cspHeader
+		}
+	})
+}
+
+func TestAllowContentTypes(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		types       []string
+		method      string
+		body        string
+		contentType string
+		status      int
+	}{
+		{[]string{"application/json"}, "POST", "{}", "application/json", http.StatusOK},
+		{[]string{"application/json"}, "POST", "{}", "Application/JSON; charset=utf-8", http.StatusOK},
+		{[]string{"application/json"}, "POST", "a=1", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{[]string{"application/json"}, "PUT", "{}", "", http.StatusUnsupportedMediaType},
+		{[]string{"application/json"}, "POST", "", "text/plain", http.StatusOK},
+		{[]string{"application/json"}, "GET", "x", "text/plain", http.StatusOK},
+		{nil, "POST", "x", "text/plain", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		AllowContentTypes(tt.types...)(next).ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s %q with %q allowing %q: got status %d, want %d", tt.method, tt.body, tt.contentType, tt.types, w.Code, tt.status)
+		}
+	}
+}
+
+func TestSecureHeadersRedirect(t *testing.T) {
+	h := SecureHeaders()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		url, want string
+	}{
+		{"http://bwsd.net/a/b.html?q=1&r=%2F", "https://bwsd.net/a/b.html?q=1&r=%2F"},
+		{"http://WWW.bwsd.net:80/search?q=go", "https://www.bwsd.net/search?q=go"},
+		{"http://example.com./?next=/", "https://example.com/?next=/"},
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	for _, tt := range tests {
+		// Send the request to the test server, with the host of tt.url.
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		u, _ := req.URL.Parse(tt.url)
+		req.URL.Path, req.URL.RawQuery, req.Host = u.Path, u.RawQuery, u.Host
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != tt.want {
+			t.Errorf("%s: got %d %q, want 301 %q", tt.url, resp.StatusCode, resp.Header.Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestSecureHeadersTrustedProxy(t *testing.T) {
+	defer func(n []*net.IPNet) { trustedProxies = n }(trustedProxies)
+	if err := SetTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatalf("%v", err)
+	}
+	h := SecureHeaders()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name       string
+		tls        bool
+		remoteAddr string
+		proto      string
+		redirect   bool
+	}{
+		{"direct TLS", true, "192.0.2.1:1234", "", false},
+		{"trusted proxy https", false, "10.1.2.3:1234", "https", false},
+		{"trusted proxy http", false, "10.1.2.3:1234", "http", true},
+		{"untrusted proxy https", false, "192.0.2.1:1234", "https", true},
+		{"plain http", false, "192.0.2.1:1234", "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "https://bwsd.net/a?b=c", nil)
+		if !tt.tls {
+			r.TLS = nil
+		}
+		r.RemoteAddr = tt.remoteAddr
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Code == http.StatusMovedPermanently; got != tt.redirect {
+			t.Errorf("%s: redirected = %v, want %v", tt.name, got, tt.redirect)
+		}
+	}
+
+	if err := SetTrustedProxies("10.0.0.0"); err == nil {
+		t.Errorf("expected error for malformed CIDR range")
+	}
+}
+
+func TestServerHeader(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream/1.0")
+		io.WriteString(w, "ok")
+	})
+	for _, value := range []string{"", "site"} {
+		srv := httptest.NewServer(ServerHeader(value)(next))
+		resp, err := http.Get(srv.URL)
+		srv.Close()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		resp.Body.Close()
+		if got, ok := resp.Header["Server"]; value == "" && ok {
+			t.Errorf("got Server %q, want none", got)
+		} else if value != "" && resp.Header.Get("Server") != value {
+			t.Errorf("got Server %q, want %q", resp.Header.Get("Server"), value)
+		}
+	}
+}
+
+func TestAcceptHeadersHeaderSize(t *testing.T) {
+	defer func(l *log.Logger) { logger = l }(logger)
+	var buf bytes.Buffer
+	logger = NewLogger(&buf)
+
+	h := AcceptHeadersWith(AcceptOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// Larger than MaxHeaderBytes but within the margin read by net/http.
+	ts := httptest.NewUnstartedServer(h)
+	ts.Config.MaxHeaderBytes = MaxHeaderBytes
+	ts.Start()
+	defer ts.Close()
+
+	tests := []struct {
+		size int
+		want int
+	}{
+		{MaxHeaderBytes / 2, http.StatusOK},
+		{MaxHeaderBytes + 1024, http.StatusRequestHeaderFieldsTooLarge},
+		{MaxHeaderBytes + 64<<10, http.StatusRequestHeaderFieldsTooLarge},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		req.Header.Set("X-Padding", strings.Repeat("a", tt.size))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("header size %d: %v", tt.size, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("header size %d: got status %d, want %d", tt.size, resp.StatusCode, tt.want)
+		}
+	}
+	if !strings.Contains(buf.String(), "request header too large") {
+		t.Errorf("expected header size rejection to be logged, got %q", buf.String())
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Default paths of the liveness and readiness endpoints.
+const (
+	DefaultHealthPath = "/healthz"
+	DefaultReadyPath  = "/readyz"
+)
+
+// Health reports server liveness and readiness, for use by container
+// orchestrators and load balancers.
+type Health struct {
+	draining atomic.Bool
+}
+
+// health is the state of the running server, drained on shutdown.
+var health Health
+
+// Drain marks the server as shutting down, after which Ready responds 503.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+// Live returns a handler responding 200 while the server is running.
+func (h *Health) Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, "ok\n")
+	})
+}
+
+// Ready returns a handler responding 200 while the server accepts requests,
+// and 503 once shutdown has begun.
+func (h *Health) Ready() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if h.draining.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			io.WriteString(w, "draining\n")
+			return
+		}
+		io.WriteString(w, "ready\n")
+	})
+}
+
+// registerHealth registers the liveness and readiness handlers of h on mux
+// at the paths given by c, or their defaults.
+func registerHealth(mux *http.ServeMux, c *Config, h *Health) {
+	live, ready := c.HealthPath, c.ReadyPath
+	if live == "" {
+		live = DefaultHealthPath
+	}
+	if ready == "" {
+		ready = DefaultReadyPath
+	}
+	mux.Handle(live, h.Live())
+	mux.Handle(ready, h.Ready())
+}
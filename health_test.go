@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	var h Health
+	mux := http.NewServeMux()
+	registerHealth(mux, &Config{ReadyPath: "/ready"}, &h)
+
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Code
+	}
+
+	if got := get(DefaultHealthPath); got != http.StatusOK {
+		t.Errorf("%s: status = %d, want 200", DefaultHealthPath, got)
+	}
+	if got := get("/ready"); got != http.StatusOK {
+		t.Errorf("/ready: status = %d, want 200", got)
+	}
+
+	h.Drain()
+
+	if got := get(DefaultHealthPath); got != http.StatusOK {
+		t.Errorf("%s: status = %d after drain, want 200", DefaultHealthPath, got)
+	}
+	if got := get("/ready"); got != http.StatusServiceUnavailable {
+		t.Errorf("/ready: status = %d after drain, want 503", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// altSvcMaxAge is the time, in seconds, for which clients may remember that
+// HTTP/3 is available.
+const altSvcMaxAge = 86400
+
+// AltSvc returns a middleware advertising HTTP/3 on the UDP port of addr, such
+// as ":443", with an Alt-Svc header (RFC 7838). Clients continue to use
+// HTTP/2 until they have connected over QUIC.
+func AltSvc(addr string) Middleware {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		port = "443"
+	}
+	altSvc := fmt.Sprintf(`h3=":%s"; ma=%d`, port, altSvcMaxAge)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Alt-Svc", altSvc)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// newHTTP3Server returns an HTTP/3 server on the UDP port of addr, sharing the
+// handler and TLS configuration of the HTTP/2 server. Requests received in
+// 0-RTT early data are rejected unless free of side effects.
+func newHTTP3Server(addr string, h http.Handler, cfg *tls.Config) *http3.Server {
+	return &http3.Server{
+		Addr:           addr,
+		Handler:        Reject0RTT()(h),
+		TLSConfig:      cfg,
+		MaxHeaderBytes: MaxHeaderBytes,
+	}
+}
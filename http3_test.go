@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAltSvc(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{":4433", `h3=":4433"; ma=86400`},
+		{"", `h3=":443"; ma=86400`},
+	}
+	for _, tt := range tests {
+		ts := httptest.NewTLSServer(AltSvc(tt.addr)(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {})),
+		)
+		resp, err := ts.Client().Get(ts.URL)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		resp.Body.Close()
+		ts.Close()
+
+		if got := resp.Header.Get("Alt-Svc"); got != tt.want {
+			t.Errorf("addr %q: Alt-Svc = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
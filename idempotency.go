@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a complete HTTP response held for replay.
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Fingerprint is the SHA-256 digest, in hex, of the body of the request
+	// answered, which requests replaying the response must match.
+	Fingerprint string
+}
+
+// replay writes the cached response to w.
+func (c *CachedResponse) replay(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range c.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(c.Status)
+	w.Write(c.Body)
+}
+
+// responseBuffer is an http.ResponseWriter recording a response in memory.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newResponseBuffer() *responseBuffer {
+	return &responseBuffer{header: make(http.Header)}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.status == 0 {
+		b.status = code
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// response returns the recorded response.
+func (b *responseBuffer) response() *CachedResponse {
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &CachedResponse{
+		Status: status,
+		Header: b.header.Clone(),
+		Body:   bytes.Clone(b.body.Bytes()),
+	}
+}
+
+// IdempotencyStore holds responses keyed by idempotency key.
+type IdempotencyStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse, ttl time.Duration)
+}
+
+// Idempotency returns a middleware which replays the response to a previous
+// request carrying the same Idempotency-Key header, so that client retries
+// are not processed twice. Keys are scoped to the client, identified by the
+// principal authenticated by APIKey, the client certificate or the remote
+// address, in that order, and to the method and path. A key reused with a
+// different request body is refused with 422 Unprocessable Entity.
+// Responses are cached in store for ttl; server errors are not cached. A
+// request arriving while another with the same key is in progress waits for,
+// and replays, its response. Requests without the header, and those with
+// safe methods such as GET, are unaffected.
+func Idempotency(store IdempotencyStore, ttl time.Duration) Middleware {
+	var (
+		mu       sync.Mutex
+		inflight = make(map[string]*idempotentCall)
+	)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := r.Header.Get("Idempotency-Key")
+			if k == "" || safeMethod(r.Method) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			key := idempotencyScope(r) + " " + r.Method + " " + r.URL.Path + " " + k
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(body)
+			fp := hex.EncodeToString(sum[:])
+
+			mu.Lock()
+			if c, ok := inflight[key]; ok {
+				mu.Unlock()
+				if c.fingerprint != fp {
+					http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+					return
+				}
+				select {
+				case <-c.done:
+					if c.resp == nil { // The handler panicked.
+						http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+						return
+					}
+					c.resp.replay(w)
+				case <-r.Context().Done():
+				}
+				return
+			}
+			if resp, ok := store.Get(key); ok {
+				mu.Unlock()
+				if resp.Fingerprint != fp {
+					http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+					return
+				}
+				resp.replay(w)
+				return
+			}
+			c := &idempotentCall{done: make(chan struct{}), fingerprint: fp}
+			inflight[key] = c
+			mu.Unlock()
+
+			defer func() {
+				mu.Lock()
+				delete(inflight, key)
+				mu.Unlock()
+				close(c.done)
+			}()
+
+			buf := newResponseBuffer()
+			h.ServeHTTP(buf, r)
+			resp := buf.response()
+			resp.Fingerprint = fp
+			if resp.Status < http.StatusInternalServerError {
+				store.Set(key, resp, ttl)
+			}
+			c.resp = resp
+			resp.replay(w)
+		})
+	}
+}
+
+// idempotentCall is a request in progress, whose response is available to
+// requests with the same key and body fingerprint once done is closed.
+type idempotentCall struct {
+	done        chan struct{}
+	fingerprint string
+	resp        *CachedResponse
+}
+
+// idempotencyScope returns the identity of the client sending r, to which
+// its idempotency keys are scoped: the principal authenticated by APIKey,
+// the common name of its client certificate or its remote address.
+func idempotencyScope(r *http.Request) string {
+	if p := PrincipalFromContext(r.Context()); p != "" {
+		return "principal:" + p
+	}
+	if cn := clientCN(r); cn != "" {
+		return "cn:" + cn
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// safeMethod reports whether method is safe (RFC 9110, section 9.2.1), so
+// that repeating a request has no effect.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Expired entries
+// are evicted periodically by a background goroutine.
+type MemoryIdempotencyStore struct {
+	m    sync.Map // string -> *idempotencyEntry
+	done chan struct{}
+}
+
+type idempotencyEntry struct {
+	resp    *CachedResponse
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns a store evicting expired entries every
+// interval. Close stops eviction.
+func NewMemoryIdempotencyStore(interval time.Duration) *MemoryIdempotencyStore {
+	s := &MemoryIdempotencyStore{done: make(chan struct{})}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				s.evict(now)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *MemoryIdempotencyStore) Get(key string) (*CachedResponse, bool) {
+	v, ok := s.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	e := v.(*idempotencyEntry)
+	if time.Now().After(e.expires) {
+		s.m.Delete(key)
+		return nil, false
+	}
+	return e.resp, true
+}
+
+func (s *MemoryIdempotencyStore) Set(key string, resp *CachedResponse, ttl time.Duration) {
+	s.m.Store(key, &idempotencyEntry{resp: resp, expires: time.Now().Add(ttl)})
+}
+
+// Close stops the eviction goroutine.
+func (s *MemoryIdempotencyStore) Close() {
+	close(s.done)
+}
+
+func (s *MemoryIdempotencyStore) evict(now time.Time) {
+	s.m.Range(func(k, v any) bool {
+		if now.After(v.(*idempotencyEntry).expires) {
+			s.m.Delete(k)
+		}
+		return true
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Hour)
+	defer store.Close()
+	var calls atomic.Int32
+	h := Idempotency(store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("X-Order", fmt.Sprint(n))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "order %d", n)
+	}))
+
+	do := func(method, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/orders", nil)
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	first := do("POST", "k1")
+	replay := do("POST", "k1")
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler called %d times, want 1", n)
+	}
+	if replay.Code != http.StatusCreated || replay.Header().Get("X-Order") != "1" || replay.Body.String() != "order 1" {
+		t.Errorf("replay = (%d, %q, %q), want (%d, %q, %q)",
+			replay.Code, replay.Header().Get("X-Order"), replay.Body.String(),
+			first.Code, first.Header().Get("X-Order"), first.Body.String())
+	}
+
+	tests := []struct {
+		method, key string
+	}{
+		{"POST", "k2"},  // another key
+		{"PATCH", "k1"}, // another method
+		{"POST", ""},    // no key
+		{"GET", "k3"},
+		{"GET", "k3"}, // safe methods are never replayed
+	}
+	for _, tt := range tests {
+		before := calls.Load()
+		do(tt.method, tt.key)
+		if calls.Load() == before {
+			t.Errorf("%s with key %q replayed", tt.method, tt.key)
+		}
+	}
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Hour)
+	defer store.Close()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	h := Idempotency(store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		fmt.Fprint(w, "done")
+	}))
+
+	const n = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest("POST", "/pay", nil)
+			r.Header.Set("Idempotency-Key", "same")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	// Give every request time to arrive before the first completes.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if c := calls.Load(); c != 1 {
+		t.Errorf("handler called %d times, want 1", c)
+	}
+	for i, b := range bodies {
+		if b != "done" {
+			t.Errorf("request %d: got body %q, want %q", i, b, "done")
+		}
+	}
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Hour)
+	defer store.Close()
+	var calls atomic.Int32
+	h := Idempotency(store, time.Hour)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d %s", calls.Add(1), b)
+	}))
+
+	do := func(principal, addr, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+		r.RemoteAddr = addr
+		r.Header.Set("Idempotency-Key", "k1")
+		if principal != "" {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey, principal))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	tests := []struct {
+		principal, addr, body string
+		status                int
+		want                  string
+	}{
+		{"alice", "192.0.2.1:1", "a", http.StatusOK, "1 a"},
+		{"alice", "192.0.2.2:2", "a", http.StatusOK, "1 a"}, // replayed
+		{"bob", "192.0.2.1:1", "a", http.StatusOK, "2 a"},   // another principal
+		{"alice", "192.0.2.1:1", "b", http.StatusUnprocessableEntity, ""},
+		{"", "192.0.2.1:1", "a", http.StatusOK, "3 a"},
+		{"", "192.0.2.1:2", "a", http.StatusOK, "3 a"}, // same address
+		{"", "192.0.2.3:1", "a", http.StatusOK, "4 a"}, // another address
+		{"", "192.0.2.3:1", "", http.StatusUnprocessableEntity, ""},
+	}
+	for i, tt := range tests {
+		w := do(tt.principal, tt.addr, tt.body)
+		if w.Code != tt.status || (tt.want != "" && w.Body.String() != tt.want) {
+			t.Errorf("%d: got %d %q, want %d %q", i, w.Code, w.Body.String(), tt.status, tt.want)
+		}
+	}
+}
+
+func TestMemoryIdempotencyStoreExpiry(t *testing.T) {
+	s := NewMemoryIdempotencyStore(time.Hour)
+	defer s.Close()
+	resp := &CachedResponse{Status: http.StatusOK}
+
+	s.Set("expired", resp, -time.Second)
+	if _, ok := s.Get("expired"); ok {
+		t.Errorf("expired entry returned")
+	}
+
+	s.Set("a", resp, time.Minute)
+	s.Set("b", resp, time.Hour)
+	s.evict(time.Now().Add(2 * time.Minute))
+	if _, ok := s.m.Load("a"); ok {
+		t.Errorf("expired entry not evicted")
+	}
+	if got, ok := s.Get("b"); !ok || got != resp {
+		t.Errorf("live entry evicted")
+	}
+}
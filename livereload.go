@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// LiveReloadPath is the path of the event stream notifying browsers of
+// changes to the site during development.
+const LiveReloadPath = "/__livereload"
+
+// liveReloadScriptPath is the path of the script subscribing to the event
+// stream, referenced from HTML responses by InjectLiveReload. The script is
+// not inline, so that the CSP need only allow scripts from 'self'.
+const liveReloadScriptPath = LiveReloadPath + ".js"
+
+const liveReloadScript = `new EventSource("` + LiveReloadPath + `").addEventListener("reload", () => location.reload());
+`
+
+const liveReloadTag = `<script src="` + liveReloadScriptPath + `"></script>
+`
+
+// LiveReload is an http.Handler streaming a "reload" event, as
+// text/event-stream, to each connected browser whenever Notify is called.
+type LiveReload struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+}
+
+// NewLiveReload returns a LiveReload without clients.
+func NewLiveReload() *LiveReload {
+	return &LiveReload{clients: make(map[chan struct{}]bool)}
+}
+
+// Notify sends a reload event to all connected browsers.
+func (lr *LiveReload) Notify() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for c := range lr.clients {
+		select {
+		case c <- struct{}{}:
+		default: // A reload is already pending.
+		}
+	}
+}
+
+func (lr *LiveReload) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == liveReloadScriptPath {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		io.WriteString(w, liveReloadScript)
+		return
+	}
+
+	c := make(chan struct{}, 1)
+	lr.mu.Lock()
+	lr.clients[c] = true
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, c)
+		lr.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	// Development servers use the site's write timeout; EventSource
+	// reconnects once it expires.
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-c:
+			if _, err := io.WriteString(w, "event: reload\ndata: \n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Watch notifies browsers whenever a file beneath dir is created, written,
+// removed or renamed, until the returned watcher is closed. Directories
+// created later are watched too.
+func (lr *LiveReload) Watch(dir string) (*fsnotify.Watcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("watch: %v", err)
+	}
+	if err := watchTree(w, dir); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("watch: %v", err)
+	}
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if ev.Has(fsnotify.Create) {
+					watchTree(w, ev.Name)
+				}
+				if ev.Has(fsnotify.Chmod) && !ev.Has(fsnotify.Write) {
+					continue
+				}
+				lr.Notify()
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logger.Printf("watch: %v", err)
+			}
+		}
+	}()
+	return w, nil
+}
+
+// watchTree adds root and the directories beneath it to w. It does nothing if
+// root is not a directory.
+func watchTree(w *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		return w.Add(name)
+	})
+}
+
+// InjectLiveReload returns a middleware appending a script subscribing to
+// LiveReloadPath to complete HTML responses, and allowing it, and its event
+// stream, by the response's Content-Security-Policy. It is meant for
+// development only.
+func InjectLiveReload() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lw := &liveReloadWriter{ResponseWriter: w}
+			h.ServeHTTP(lw, r)
+			if lw.inject && r.Method != "HEAD" {
+				io.WriteString(w, liveReloadTag)
+			}
+		})
+	}
+}
+
+// liveReloadWriter decides, when the header is written, whether to append
+// the live reload script to the response.
+type liveReloadWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	inject      bool
+}
+
+func (w *liveReloadWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	hdr := w.Header()
+	ctype := strings.ToLower(hdr.Get("Content-Type"))
+	if code == http.StatusOK && strings.HasPrefix(ctype, "text/html") && hdr.Get("Content-Encoding") == "" {
+		w.inject = true
+		hdr.Del("Content-Length")
+		if csp := hdr.Get("Content-Security-Policy"); csp != "" {
+			// The header value may be shared between responses, so is
+			// replaced rather than modified.
+			hdr["Content-Security-Policy"] = []string{cspAllowSelf(csp, "script-src", "connect-src")}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *liveReloadWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (w *liveReloadWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// cspAllowSelf returns the Content-Security-Policy csp with 'self' allowed
+// by each of directives, in place of 'none'.
+func cspAllowSelf(csp string, directives ...string) string {
+	var parts []string
+	for _, p := range strings.Split(csp, ";") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	for _, d := range directives {
+		found := false
+		for i, p := range parts {
+			fields := strings.Fields(p)
+			if fields[0] != d {
+				continue
+			}
+			found = true
+			if !slices.Contains(fields, cspSelf) {
+				fields = slices.DeleteFunc(fields, func(f string) bool { return f == cspNone })
+				parts[i] = strings.Join(append(fields, cspSelf), " ")
+			}
+		}
+		if !found {
+			parts = append(parts, d+" "+cspSelf)
+		}
+	}
+	return strings.Join(parts, ";")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveReloadWatch(t *testing.T) {
+	dir := t.TempDir()
+	lr := NewLiveReload()
+	w, err := lr.Watch(dir)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer w.Close()
+
+	ts := httptest.NewServer(lr)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + LiveReloadPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("got Content-Type %q, want text/event-stream", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("changed"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		if line != "event: reload\n" {
+			t.Errorf("got %q, want reload event", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("no reload event after file change")
+	}
+}
+
+func TestInjectLiveReload(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range map[string]string{"index.html": "<p>hi</p>", "site.css": "p{}"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	h := Apply(SecureHeaders(), InjectLiveReload())(http.FileServer(http.Dir(dir)))
+	ts := httptest.NewTLSServer(h)
+	defer ts.Close()
+
+	tests := []struct {
+		path   string
+		inject bool
+	}{
+		{"/index.html", true},
+		{"/site.css", false},
+	}
+	for _, tt := range tests {
+		resp, err := ts.Client().Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if got := strings.HasSuffix(string(b), liveReloadTag); got != tt.inject {
+			t.Errorf("%s: script injected = %v, want %v: %q", tt.path, got, tt.inject, b)
+		}
+		csp := resp.Header.Get("Content-Security-Policy")
+		if got := strings.Contains(csp, "script-src 'self'"); got != tt.inject {
+			t.Errorf("%s: CSP %q allows scripts = %v, want %v", tt.path, csp, got, tt.inject)
+		}
+	}
+}
+
+func TestCSPAllowSelf(t *testing.T) {
+	tests := []struct {
+		csp, want string
+	}{
+		{"default-src 'none';img-src 'self'", "default-src 'none';img-src 'self';script-src 'self';connect-src 'self'"},
+		{"script-src 'none';connect-src https://example.com", "script-src 'self';connect-src https://example.com 'self'"},
+		{"script-src 'self';connect-src 'self'", "script-src 'self';connect-src 'self'"},
+	}
+	for _, tt := range tests {
+		if got := cspAllowSelf(tt.csp, "script-src", "connect-src"); got != tt.want {
+			t.Errorf("cspAllowSelf(%q) = %q, want %q", tt.csp, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultLogBackups is the number of rotated log files kept when none is
+// given.
+const DefaultLogBackups = 7
+
+// RotateOptions configures when a RotatingFile is rotated and how many
+// rotated files are kept.
+type RotateOptions struct {
+	// MaxSize is the size, in bytes, beyond which the file is rotated.
+	// Zero disables rotation by size.
+	MaxSize int64
+
+	// MaxAge is the time after which the file is rotated. Zero disables
+	// rotation by age.
+	MaxAge time.Duration
+
+	// Backups is the number of rotated files kept, named name.1 (the most
+	// recent), name.2 and so on. Zero selects DefaultLogBackups.
+	Backups int
+
+	// Compress gzips rotated files, adding a .gz extension.
+	Compress bool
+}
+
+// RotatingFile is an io.Writer appending to a log file, which is renamed and
+// reopened when it grows beyond a size or age threshold.
+type RotatingFile struct {
+	mu     sync.Mutex
+	name   string
+	opts   RotateOptions
+	f      *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+
+	// compressing tracks the compression of the most recent backup, done
+	// outside mu so that writes are not held up. The first error is kept
+	// in compressErr, read after compressing.Wait, for Close.
+	compressing sync.WaitGroup
+	compressErr error
+}
+
+// OpenRotatingFile opens the named log file for appending, creating it if
+// necessary.
+func OpenRotatingFile(name string, opts RotateOptions) (*RotatingFile, error) {
+	if opts.Backups == 0 {
+		opts.Backups = DefaultLogBackups
+	}
+	rf := &RotatingFile{name: name, opts: opts, now: time.Now}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("log file: %v", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("log file: %v", err)
+	}
+	rf.f, rf.size, rf.opened = f, fi.Size(), rf.now()
+	return nil
+}
+
+// Write appends p to the file, first rotating it if p would take it beyond
+// MaxSize or it is older than MaxAge.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	full := rf.opts.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.opts.MaxSize
+	old := rf.opts.MaxAge > 0 && rf.now().Sub(rf.opened) >= rf.opts.MaxAge
+	if full || old {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Rotate renames the file to name.1, shifting older backups, and reopens it.
+// If the file cannot be renamed, writes continue to append to it.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.rotate()
+}
+
+func (rf *RotatingFile) rotate() error {
+	// The previous backup is compressed before it is shifted.
+	rf.compressing.Wait()
+	if err := rf.f.Close(); err != nil {
+		return fmt.Errorf("log file: %v", err)
+	}
+	rotated, err := rf.shift()
+	if err != nil {
+		if oerr := rf.open(); oerr != nil {
+			return oerr
+		}
+		return fmt.Errorf("log file: %v", err)
+	}
+	if rf.opts.Compress {
+		rf.compressing.Add(1)
+		go func() {
+			defer rf.compressing.Done()
+			// The error is not logged, as the logger may write to rf.
+			if err := gzipFile(rotated); err != nil && rf.compressErr == nil {
+				rf.compressErr = fmt.Errorf("log file: %v", err)
+			}
+		}()
+	}
+	return rf.open()
+}
+
+// shift renames the file to name.1, after shifting older backups and
+// removing the oldest, and returns the new name.
+func (rf *RotatingFile) shift() (string, error) {
+	ext := ""
+	if rf.opts.Compress {
+		ext = ".gz"
+	}
+	backup := func(n int) string {
+		return fmt.Sprintf("%s.%d%s", rf.name, n, ext)
+	}
+
+	os.Remove(backup(rf.opts.Backups))
+	for n := rf.opts.Backups - 1; n > 0; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	rotated := fmt.Sprintf("%s.1", rf.name)
+	if err := os.Rename(rf.name, rotated); err != nil {
+		return "", err
+	}
+	return rotated, nil
+}
+
+// Close closes the file, after waiting for the compression of any backup.
+// It returns the first error compressing a backup, if any.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.compressing.Wait()
+	return errors.Join(rf.compressErr, rf.f.Close())
+}
+
+// gzipFile replaces the named file with a gzip-compressed copy, name.gz.
+func gzipFile(name string) error {
+	if _, err := compressFile(name, ".gz", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	}); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileSize(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(name, RotateOptions{MaxSize: 20, Backups: 2})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := io.WriteString(rf, line); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	want := map[string]string{
+		name:        "fourth line\n",
+		name + ".1": "third line\n",
+		name + ".2": "second line\n",
+	}
+	for file, content := range want {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		if string(b) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), b, content)
+		}
+	}
+	if _, err := os.Stat(name + ".3"); err == nil {
+		t.Errorf("more than 2 backups kept")
+	}
+}
+
+func TestRotatingFileAgeCompress(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(name, RotateOptions{MaxAge: time.Hour, Compress: true})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer rf.Close()
+	now := time.Now()
+	rf.now = func() time.Time { return now }
+
+	io.WriteString(rf, "yesterday\n")
+	now = now.Add(time.Hour)
+	io.WriteString(rf, "today\n")
+	rf.compressing.Wait()
+
+	f, err := os.Open(name + ".1.gz")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil || string(b) != "yesterday\n" {
+		t.Errorf("rotated file = %q, %v; want %q", b, err, "yesterday\n")
+	}
+	if _, err := os.Stat(name + ".1"); err == nil {
+		t.Errorf("uncompressed backup kept")
+	}
+	if b, _ := os.ReadFile(name); !strings.HasPrefix(string(b), "today") {
+		t.Errorf("log file = %q, want %q", b, "today\n")
+	}
+}
+
+func TestRotatingFileRenameError(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "access.log")
+	rf, err := OpenRotatingFile(name, RotateOptions{Backups: 1})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer rf.Close()
+	// A directory in place of the backup cannot be replaced by the file.
+	if err := os.MkdirAll(filepath.Join(name+".1", "x"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	io.WriteString(rf, "before\n")
+	if err := rf.Rotate(); err == nil {
+		t.Errorf("expected error renaming over a directory")
+	}
+	if _, err := io.WriteString(rf, "after\n"); err != nil {
+		t.Fatalf("write after failed rotation: %v", err)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "before\nafter\n" {
+		t.Errorf("log file = %q, want %q", b, "before\nafter\n")
+	}
+}
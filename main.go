@@ -4,34 +4,314 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"log"
+	"log/syslog"
+	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 var (
-	addr     = flag.String("addr", ":4433", "listen address")
-	selfSign = flag.Bool("s", true, "self-sign X509 certificate")
-	dirCache = flag.String("c", "/etc/ssl/private", "X509 certificate cache")
-	fsDir    = flag.String("fsdir", "static", "file system directory")
+	addr      = flag.String("addr", ":4433", "listen address, or unix:path for a Unix domain socket")
+	httpAddr  = flag.String("http-addr", ":80", "HTTPS redirect and ACME HTTP-01 challenge listen address (empty to disable)")
+	unixSock  = flag.String("unix", "", "Unix domain socket path (overrides -addr)")
+	unixMode  = flag.String("unix-mode", "", "Unix domain socket file mode, e.g. 0660")
+	selfSign  = flag.Bool("s", true, "self-sign X509 certificate")
+	dirCache  = flag.String("c", "/etc/ssl/private", "X509 certificate cache")
+	fsDir     = flag.String("fsdir", "static", "file system directory")
+	certFile  = flag.String("cert", "", "X509 certificate file (PEM)")
+	keyFile   = flag.String("key", "", "X509 private key file (PEM)")
+	keyType   = flag.String("key-type", DefaultKeyType, "self-signed X509 key type: "+strings.Join(KeyTypes, ", "))
+	clientCA  = flag.String("client-ca", "", "client certificate CA bundle (PEM)")
+	tlsMin    = flag.String("tls-min", "1.3", "minimum TLS version: 1.2 or 1.3")
+	acmeStage = flag.Bool("acme-staging", false, "use the Let's Encrypt staging environment")
+	acmeDir   = flag.String("acme-directory", "", "ACME CA directory URL")
+	healthz   = flag.String("healthz", DefaultHealthPath, "liveness endpoint path")
+	readyz    = flag.String("readyz", DefaultReadyPath, "readiness endpoint path")
+	metricsOn = flag.Bool("metrics", false, "serve metrics at "+DefaultMetricsPath)
+	metricsIP = flag.String("metrics-allow", "127.0.0.1/32,::1/128", "comma-separated CIDR ranges allowed to read metrics")
+	hosts     = flag.String("hosts", "", "comma-separated list of hosts served, and issued ACME certificates")
+	proxies   = flag.String("trusted-proxies", "", "comma-separated CIDR ranges of TLS-terminating proxies whose X-Forwarded-Proto is trusted")
+	noIndex   = flag.Bool("noindex", false, "keep crawlers out: mark responses noindex and disallow all paths in robots.txt")
+	srvHeader = flag.String("server-header", "", "Server response header (empty to omit)")
+	canonical = flag.String("canonical-host", "", "redirect the www or apex counterpart of this host to it")
+	logOutput = flag.String("log-output", "stdout", "log output: stdout, stderr or syslog")
+	errorLog  = flag.String("error-log", "", "error log output: stdout, stderr or syslog; if set, the other -log flags select the access log")
+	logSyslog = flag.String("log-syslog", "", "also send logs to syslog: local, udp://host:port or tcp://host:port")
+	sysFacil  = flag.String("syslog-facility", "daemon", "syslog facility of -log-output syslog and -log-syslog, e.g. daemon or local0")
+	sysTag    = flag.String("syslog-tag", "site", "syslog tag of -log-output syslog and -log-syslog")
+	logFile   = flag.String("log-file", "", "log file, rotated by size or age (overrides -log-output)")
+	logSize   = flag.Int64("log-max-size", 100, "log file size in MiB beyond which it is rotated (0 to disable)")
+	logAge    = flag.Duration("log-max-age", 0, "log file age after which it is rotated (0 to disable)")
+	logBackup = flag.Int("log-backups", DefaultLogBackups, "number of rotated log files kept")
+	logGzip   = flag.Bool("log-compress", false, "gzip rotated log files")
+	redirects = flag.String("redirects", "", "redirect rules file (_redirects format)")
+	http3On   = flag.Bool("http3", false, "serve HTTP/3 (QUIC) on the UDP port of -addr")
+	proxyProt = flag.Bool("proxy-protocol", false, "read PROXY protocol headers from TCP connections")
+	readTO    = flag.Duration("read-timeout", DefaultServerTimeouts.Read, "request read timeout")
+	writeTO   = flag.Duration("write-timeout", DefaultServerTimeouts.Write, "response write timeout")
+	idleTO    = flag.Duration("idle-timeout", DefaultServerTimeouts.Idle, "keep-alive idle timeout")
+	pprofAddr = flag.String("pprof", "", "serve net/http/pprof on this loopback address or unix:path")
+	watch     = flag.Bool("watch", false, "reload browsers when files in -fsdir change (development only, requires -s)")
+	showVer   = flag.Bool("version", false, "print version information and exit")
+	maxURILen = flag.Int("max-uri-len", MaxURILen, "maximum request URI length in bytes")
+	maintFile = flag.String("maintenance", "", "serve 503 Service Unavailable while this file exists")
+	maintPage = flag.String("maintenance-page", "", "page served during maintenance")
+	config    = flag.String("config", "", "configuration file (TOML or JSON); flags and SITE_* environment variables take precedence")
 )
 
-const usageLine = `usage: site [-addr addr] [-s] [-c certdir] [-fsdir dir]
+const usageLine = `usage: site sri [-fsdir dir]
+       site compress [-fsdir dir] [-min-size bytes]
+       site -version
+       site [-config file] [-addr addr | -unix path [-unix-mode mode]] [-s [-watch]] [-c certdir] [-fsdir dir] [-cert file -key file]
+            [-key-type type] [-tls-min version] [-client-ca file]
+            [-acme-staging | -acme-directory url] [-http-addr addr]
+            [-hosts host,...] [-canonical-host host] [-trusted-proxies cidr,...]
+            [-noindex] [-server-header value] [-healthz path] [-readyz path]
+            [-metrics [-metrics-allow cidr,...]]
+            [-log-output stdout|stderr|syslog] [-error-log stdout|stderr|syslog] [-redirects file]
+            [-log-syslog addr] [-syslog-facility name] [-syslog-tag tag]
+            [-log-file file [-log-max-size mib] [-log-max-age d] [-log-backups n] [-log-compress]]
+            [-max-uri-len n] [-http3] [-proxy-protocol]
+            [-read-timeout d] [-write-timeout d] [-idle-timeout d] [-pprof addr]
+            [-mount /prefix=dir ...] [-maintenance file [-maintenance-page file]]
 options:
 `
 
+// mountFlags is a flag.Value collecting -mount flags.
+type mountFlags []Mount
+
+func (m *mountFlags) String() string {
+	s := make([]string, len(*m))
+	for i, mt := range *m {
+		s[i] = mt.Prefix + "=" + mt.Dir
+	}
+	return strings.Join(s, ",")
+}
+
+func (m *mountFlags) Set(v string) error {
+	mt, err := ParseMount(v)
+	if err != nil {
+		return err
+	}
+	*m = append(*m, mt)
+	return nil
+}
+
+var mounts mountFlags
+
+func init() {
+	flag.Var(&mounts, "mount", "serve a directory beneath a URL path prefix, as /prefix=dir (repeatable)")
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "%s", usageLine)
 	flag.PrintDefaults()
 	os.Exit(2)
 }
 
+// openLog returns a logger for the named output. The syslog output is the
+// local service, logged to with the given tag and facility.
+func openLog(output, tag string, facility syslog.Priority) (*log.Logger, error) {
+	switch output {
+	case "stdout":
+		return NewLogger(os.Stdout), nil
+	case "stderr":
+		return NewLogger(os.Stderr), nil
+	case "syslog":
+		return NewSyslogLogger("local", tag, facility)
+	}
+	return nil, fmt.Errorf("unknown log output %q", output)
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sri":
+			os.Exit(sriMain(os.Args[2:]))
+		case "compress":
+			os.Exit(compressMain(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
+	if err := ApplyEnv(flag.CommandLine, EnvPrefix); err != nil {
+		fmt.Fprintf(os.Stderr, "site: %v\n", err)
+		usage()
+	}
+	if *config != "" {
+		settings, err := LoadConfigFile(*config)
+		if err == nil {
+			err = ApplyConfig(flag.CommandLine, settings)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "site: %v\n", err)
+			usage()
+		}
+	}
+	if *showVer {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
 	if *dirCache == "" {
 		usage()
 	}
+	if (*certFile == "") != (*keyFile == "") {
+		usage()
+	}
+
+	if *watch && !*selfSign {
+		fmt.Fprintf(os.Stderr, "site: -watch is for development only, and requires -s\n")
+		usage()
+	}
+
+	if !slices.Contains(KeyTypes, *keyType) {
+		fmt.Fprintf(os.Stderr, "site: unknown key type %q\n", *keyType)
+		usage()
+	}
+
+	tlsMinVersion, err := ParseTLSVersion(*tlsMin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "site: %v\n", err)
+		usage()
+	}
+
+	acmeDirectory, err := ACMEDirectoryURL(*acmeStage, *acmeDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "site: %v\n", err)
+		usage()
+	}
+
+	var unixOpts UnixOptions
+	if *unixMode != "" {
+		mode, err := strconv.ParseUint(*unixMode, 8, 32)
+		if err != nil || mode > 0777 {
+			fmt.Fprintf(os.Stderr, "site: invalid socket mode %q\n", *unixMode)
+			usage()
+		}
+		unixOpts.Mode = os.FileMode(mode)
+	}
+
+	if *readTO <= 0 || *writeTO <= 0 || *idleTO <= 0 {
+		fmt.Fprintf(os.Stderr, "site: timeouts must be positive\n")
+		usage()
+	}
+
+	if *maxURILen <= 0 {
+		fmt.Fprintf(os.Stderr, "site: invalid maximum URI length %d\n", *maxURILen)
+		usage()
+	}
+	MaxURILen = *maxURILen
+
+	// The hosts are served from -fsdir, and ACME certificates issued for
+	// them.
+	var vhosts map[string]http.Handler
+	if *hosts != "" {
+		vhosts = make(map[string]http.Handler)
+		for _, h := range strings.Split(*hosts, ",") {
+			vhosts[strings.TrimSpace(h)] = nil
+		}
+	}
+	if vhosts == nil && *certFile == "" && !*selfSign {
+		fmt.Fprintf(os.Stderr, "site: ACME certificates require -hosts\n")
+		usage()
+	}
+
+	if *proxies != "" {
+		if err := SetTrustedProxies(strings.Split(*proxies, ",")...); err != nil {
+			fmt.Fprintf(os.Stderr, "site: trusted proxies: %v\n", err)
+			usage()
+		}
+	}
+
+	var rules []RedirectRule
+	if *redirects != "" {
+		if rules, err = LoadRedirectsFile(*redirects); err != nil {
+			fmt.Fprintf(os.Stderr, "site: %v\n", err)
+			usage()
+		}
+	}
+
+	facility, err := ParseSyslogFacility(*sysFacil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "site: %v\n", err)
+		usage()
+	}
+	var l *log.Logger
+	if *logFile != "" {
+		if *logBackup <= 0 {
+			fmt.Fprintf(os.Stderr, "site: invalid number of log backups %d\n", *logBackup)
+			usage()
+		}
+		var rf *RotatingFile
+		rf, err = OpenRotatingFile(*logFile, RotateOptions{
+			MaxSize:  *logSize << 20,
+			MaxAge:   *logAge,
+			Backups:  *logBackup,
+			Compress: *logGzip,
+		})
+		l = NewLogger(rf)
+	} else {
+		l, err = openLog(*logOutput, *sysTag, facility)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "site: %v\n", err)
+		usage()
+	}
+	if *logSyslog != "" {
+		if sl, err := NewSyslogLogger(*logSyslog, *sysTag, facility); err != nil {
+			fmt.Fprintf(os.Stderr, "site: %v; not logging to syslog\n", err)
+		} else {
+			l.SetOutput(io.MultiWriter(l.Writer(), sl.Writer()))
+		}
+	}
+	if *errorLog != "" {
+		el, err := openLog(*errorLog, *sysTag, facility)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "site: %v\n", err)
+			usage()
+		}
+		SetAccessLogger(l)
+		l = el
+	}
+	SetLogger(l)
 
 	if port := os.Getenv("PORT"); port != "" {
 		*addr = ":" + port
 	}
-	Server(*fsDir, *addr, *dirCache, *selfSign)
+	Server(&Config{
+		Addr:       *addr,
+		UnixSocket: *unixSock,
+		Unix:       unixOpts,
+		FSDir:      *fsDir,
+		DirCache:   *dirCache,
+		SelfSign:   *selfSign,
+		Watch:      *watch,
+		CertFile:   *certFile,
+		KeyFile:    *keyFile,
+		KeyType:    *keyType,
+
+		TLSMinVersion: tlsMinVersion,
+		Timeouts:      ServerTimeouts{Read: *readTO, Write: *writeTO, Idle: *idleTO},
+		ClientCAFile:  *clientCA,
+		ACMEDirectory: acmeDirectory,
+		HTTPAddr:      *httpAddr,
+		PprofAddr:     *pprofAddr,
+		HealthPath:    *healthz,
+		ReadyPath:     *readyz,
+		Metrics:       *metricsOn,
+		MetricsAllow:  strings.Split(*metricsIP, ","),
+		CanonicalHost: *canonical,
+		VirtualHosts:  vhosts,
+		NoIndex:       *noIndex,
+		ServerHeader:  *srvHeader,
+		Redirects:     rules,
+		Mounts:        mounts,
+		HTTP3:         *http3On,
+		ProxyProtocol: *proxyProt,
+		Maintenance:   MaintenanceOptions{File: *maintFile, Page: *maintPage},
+	})
 }
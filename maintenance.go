@@ -0,0 +1,89 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRetryAfter is the delay advertised to clients during maintenance when
+// none is given.
+const DefaultRetryAfter = 5 * time.Minute
+
+// acmeChallengePrefix is the path prefix of ACME HTTP-01 challenges.
+const acmeChallengePrefix = WellKnownPrefix + "acme-challenge/"
+
+// MaintenanceOptions configures the Maintenance middleware.
+type MaintenanceOptions struct {
+	// File is the sentinel file whose presence enables maintenance mode.
+	// It is checked on each request, so that maintenance may be started
+	// and ended without restarting the server.
+	File string
+
+	// Page, if set, is the file served as the body of maintenance
+	// responses, e.g. an HTML page. It is read on each request during
+	// maintenance.
+	Page string
+
+	// RetryAfter is the delay advertised in the Retry-After header. Zero
+	// selects DefaultRetryAfter.
+	RetryAfter time.Duration
+
+	// Exempt are the paths served as usual during maintenance; a path
+	// ending in '/' exempts the subtree beneath it. If nil, the default
+	// health and readiness endpoints and ACME challenges are exempt.
+	Exempt []string
+}
+
+// Maintenance returns a middleware responding 503 Service Unavailable, with a
+// Retry-After header, to requests for paths not exempt while opts.File
+// exists.
+func Maintenance(opts MaintenanceOptions) Middleware {
+	retry := opts.RetryAfter
+	if retry == 0 {
+		retry = DefaultRetryAfter
+	}
+	retryAfter := strconv.Itoa(int(retry.Seconds()))
+	exempt := opts.Exempt
+	if exempt == nil {
+		exempt = []string{DefaultHealthPath, DefaultReadyPath, acmeChallengePrefix}
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := os.Stat(opts.File); err != nil || exemptPath(r.URL.Path, exempt) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Retry-After", retryAfter)
+			w.Header().Set("Cache-Control", "no-store")
+			if opts.Page != "" {
+				if b, err := os.ReadFile(opts.Page); err == nil {
+					ctype := mime.TypeByExtension(filepath.Ext(opts.Page))
+					if ctype == "" {
+						ctype = http.DetectContentType(b)
+					}
+					w.Header().Set("Content-Type", ctype)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write(b)
+					return
+				}
+			}
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		})
+	}
+}
+
+// exemptPath reports whether p is one of paths, or beneath one ending in '/'.
+func exemptPath(p string, paths []string) bool {
+	for _, e := range paths {
+		if p == e || strings.HasSuffix(e, "/") && strings.HasPrefix(p, e) {
+			return true
+		}
+	}
+	return false
+}
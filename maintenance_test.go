@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaintenance(t *testing.T) {
+	dir := t.TempDir()
+	sentinel := filepath.Join(dir, "maintenance")
+	page := filepath.Join(dir, "maintenance.html")
+	if err := os.WriteFile(page, []byte("<h1>Back soon</h1>"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	h := Maintenance(MaintenanceOptions{File: sentinel, Page: page})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	if w := get("/index.html"); w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("disabled: got %d %q, want 200 ok", w.Code, w.Body.String())
+	}
+
+	if err := os.WriteFile(sentinel, nil, 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	w := get("/index.html")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("enabled: got status %d, want 503", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "300" {
+		t.Errorf("Retry-After = %q, want 300", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if w.Body.String() != "<h1>Back soon</h1>" {
+		t.Errorf("body = %q, want the maintenance page", w.Body.String())
+	}
+	for _, p := range []string{DefaultHealthPath, DefaultReadyPath, "/.well-known/acme-challenge/token"} {
+		if w := get(p); w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d during maintenance, want 200", p, w.Code)
+		}
+	}
+
+	if err := os.Remove(sentinel); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if w := get("/index.html"); w.Code != http.StatusOK {
+		t.Errorf("after removing %s: got status %d, want 200", sentinel, w.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMetricsPath is the path of the metrics endpoint.
+const DefaultMetricsPath = "/metrics"
+
+// durationBuckets are the upper bounds, in seconds, of the request duration
+// histogram.
+var durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics holds the metrics of the running server, if enabled.
+var metrics *Metrics
+
+// Metrics collects request statistics and exposes them in the Prometheus
+// text exposition format.
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[int]uint64 // by status code
+	buckets  []uint64       // cumulative counts per durationBuckets
+	sum      float64        // total request duration in seconds
+	count    uint64
+	inFlight int64
+	bytes    uint64
+}
+
+// NewMetrics returns an empty set of metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: make(map[int]uint64),
+		buckets:  make([]uint64, len(durationBuckets)),
+	}
+}
+
+// Collect is a middleware recording the status, duration and size of each
+// response.
+func (m *Metrics) Collect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		m.inFlight++
+		m.mu.Unlock()
+
+		t0 := time.Now()
+		wr := &statusRecorder{w, 200, 0}
+		next.ServeHTTP(wr, r)
+		m.observe(wr.status, wr.size, time.Since(t0))
+	})
+}
+
+func (m *Metrics) observe(status, size int, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	m.requests[status]++
+	m.bytes += uint64(size)
+	m.count++
+	m.sum += took.Seconds()
+	for i, le := range durationBuckets {
+		if took.Seconds() <= le {
+			m.buckets[i]++
+		}
+	}
+}
+
+// ServeHTTP writes the current metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	codes := make([]int, 0, len(m.requests))
+	for c := range m.requests {
+		codes = append(codes, c)
+	}
+	sort.Ints(codes)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP http_requests_total Total HTTP requests by status code.\n")
+	fmt.Fprintf(w, "# TYPE http_requests_total counter\n")
+	for _, c := range codes {
+		fmt.Fprintf(w, "http_requests_total{code=\"%d\"} %d\n", c, m.requests[c])
+	}
+	fmt.Fprintf(w, "# HELP http_request_duration_seconds HTTP request duration.\n")
+	fmt.Fprintf(w, "# TYPE http_request_duration_seconds histogram\n")
+	for i, le := range durationBuckets {
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), m.buckets[i])
+	}
+	fmt.Fprintf(w, "http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(w, "http_request_duration_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(w, "http_request_duration_seconds_count %d\n", m.count)
+	fmt.Fprintf(w, "# HELP http_requests_in_flight HTTP requests currently being served.\n")
+	fmt.Fprintf(w, "# TYPE http_requests_in_flight gauge\n")
+	fmt.Fprintf(w, "http_requests_in_flight %d\n", m.inFlight)
+	fmt.Fprintf(w, "# HELP http_response_bytes_total Total bytes of HTTP response bodies served.\n")
+	fmt.Fprintf(w, "# TYPE http_response_bytes_total counter\n")
+	fmt.Fprintf(w, "http_response_bytes_total %d\n", m.bytes)
+	m.mu.Unlock()
+
+	cs := connMetrics.Summary()
+	fmt.Fprintf(w, "# HELP http_connections Open connections by state.\n")
+	fmt.Fprintf(w, "# TYPE http_connections gauge\n")
+	fmt.Fprintf(w, "http_connections{state=\"open\"} %d\n", cs.Open)
+	fmt.Fprintf(w, "http_connections{state=\"idle\"} %d\n", cs.Idle)
+	fmt.Fprintf(w, "http_connections{state=\"active\"} %d\n", cs.Active)
+	fmt.Fprintf(w, "# HELP http_connection_bytes_total Total bytes transferred over connections.\n")
+	fmt.Fprintf(w, "# TYPE http_connection_bytes_total counter\n")
+	fmt.Fprintf(w, "http_connection_bytes_total{direction=\"in\"} %d\n", cs.BytesIn)
+	fmt.Fprintf(w, "http_connection_bytes_total{direction=\"out\"} %d\n", cs.BytesOut)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	allow, err := AllowIPs("192.0.2.0/24")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(DefaultMetricsPath, allow(m))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	h := m.Collect(mux)
+
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", DefaultMetricsPath, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("scrape: status = %d, want 200", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	before := scrape()
+	if !strings.Contains(before, `http_requests_total{code="200"} 1`) {
+		t.Errorf("request not counted:\n%s", before)
+	}
+	if !strings.Contains(before, "http_response_bytes_total 5\n") {
+		t.Errorf("bytes not counted:\n%s", before)
+	}
+	if !strings.Contains(before, "http_request_duration_seconds_count 1\n") {
+		t.Errorf("duration not observed:\n%s", before)
+	}
+	if !strings.Contains(before, "http_requests_in_flight 1\n") {
+		t.Errorf("scrape not in flight:\n%s", before)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	after := scrape()
+	if !strings.Contains(after, `http_requests_total{code="200"} 3`) {
+		t.Errorf("requests not incremented:\n%s", after)
+	}
+
+	r := httptest.NewRequest("GET", DefaultMetricsPath, nil)
+	r.RemoteAddr = "198.51.100.1:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("disallowed client: status = %d, want 403", w.Code)
+	}
+}
@@ -1,24 +1,110 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	Default = Apply(Log, Recover)
-	logger  = log.New(os.Stdout, "site: ", 0)
+	logger  = NewLogger(os.Stdout)
 )
 
+// NewLogger returns a logger writing to w, suitable for use by the Log and
+// Recover middlewares.
+func NewLogger(w io.Writer) *log.Logger {
+	return log.New(w, "site: ", 0)
+}
+
+// SetLogger replaces the logger used by the Log and Recover middlewares and
+// the server. It must be called before the server is started; use
+// SetLogOutput to redirect logs while serving.
+func SetLogger(l *log.Logger) {
+	logger = l
+}
+
+// accessLogger, if non-nil, receives the access log lines of the Log
+// middleware, leaving logger to errors such as panics.
+var accessLogger *log.Logger
+
+// SetAccessLogger sets the logger receiving access log lines, separating them
+// from error messages, which continue to go to the logger set by SetLogger.
+// A nil logger sends both to the same logger. It must be called before the
+// server is started.
+func SetAccessLogger(l *log.Logger) {
+	accessLogger = l
+}
+
+// accessLog returns the logger receiving access log lines.
+func accessLog() *log.Logger {
+	if accessLogger != nil {
+		return accessLogger
+	}
+	return logger
+}
+
+// SetLogOutput sets the destination of the current logger, e.g. to a file or
+// buffer. It is safe to call while requests are being served.
+func SetLogOutput(w io.Writer) {
+	logger.SetOutput(w)
+}
+
+// LogBackend receives the access log entries of the Log middleware, e.g. to
+// store them in a structured log system. Entries may be read through String
+// and SlogRecord.
+type LogBackend interface {
+	Log(entry *CLFEntry)
+}
+
+// logBackend, if non-nil, receives access log entries in place of logger.
+var logBackend LogBackend
+
+// SetLogBackend sets the backend receiving the entries of the Log
+// middleware in place of the logger, which continues to receive other
+// messages. A nil backend restores logging in Combined Log Format. It must
+// be called before the server is started.
+func SetLogBackend(b LogBackend) {
+	logBackend = b
+}
+
+// SlogBackend is a LogBackend passing the entries of the Log middleware to
+// Handler as records built by SlogRecord. While it is set, Recover also
+// passes panics to Handler, with the request UUID and stack as attributes.
+type SlogBackend struct {
+	Handler slog.Handler
+}
+
+// Log passes the record of entry to b.Handler.
+func (b SlogBackend) Log(entry *CLFEntry) {
+	b.Handler.Handle(context.Background(), entry.SlogRecord())
+}
+
+// SlogLogger returns a logger passing each line written to it to h as a
+// record at level Info, so that structured handlers may be used in place of
+// the package logger.
+func SlogLogger(h slog.Handler) *log.Logger {
+	return slog.NewLogLogger(h, slog.LevelInfo)
+}
+
 type Middleware func(http.Handler) http.Handler
 
 // Apply returns a Middleware that applies a sequence of Middlewares to an http
@@ -37,19 +123,157 @@ func Apply(m ...Middleware) Middleware {
 	}
 }
 
+// Chain is a sequence of middlewares which may be extended during setup, e.g.
+// depending on flags. Middlewares are executed in the order added, as by
+// Apply:
+//
+//	var c Chain
+//	c.Use(m1)
+//	if verbose {
+//		c.Use(m2)
+//	}
+//	c.Then(handler) // Apply(m1, m2)(handler)
+type Chain []Middleware
+
+// Use appends m to the chain.
+func (c *Chain) Use(m ...Middleware) {
+	*c = append(*c, m...)
+}
+
+// Then returns h wrapped by the middlewares of the chain.
+func (c Chain) Then(h http.Handler) http.Handler {
+	return Apply(c...)(h)
+}
+
+// NamedMiddleware returns mw recording name in the request context before
+// calling it, so that the middlewares a request passed through may be listed
+// by MiddlewareNames.
+func NamedMiddleware(name string, mw Middleware) Middleware {
+	return func(h http.Handler) http.Handler {
+		wrapped := mw(h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			names := MiddlewareNames(r.Context())
+			names = append(names[:len(names):len(names)], name)
+			ctx := context.WithValue(r.Context(), middlewareNamesKey, names)
+			wrapped.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// NamedLayer is a middleware labelled for debugging. See ApplyNamed.
+type NamedLayer struct {
+	Name       string
+	Middleware Middleware
+}
+
+// ApplyNamed is like Apply, recording the name of each middleware as by
+// NamedMiddleware.
+func ApplyNamed(m ...NamedLayer) Middleware {
+	mw := make([]Middleware, len(m))
+	for i, l := range m {
+		mw[i] = NamedMiddleware(l.Name, l.Middleware)
+	}
+	return Apply(mw...)
+}
+
+// MiddlewareNames returns the names of the middlewares recorded by
+// NamedMiddleware which have processed the request, in order.
+func MiddlewareNames(ctx context.Context) []string {
+	names, _ := ctx.Value(middlewareNamesKey).([]string)
+	return names
+}
+
+// Unless returns a Middleware applying mw only to requests whose path does not
+// match pattern, as reported by path.Match. For example, to skip
+// authentication for health checks:
+//
+// Apply(Unless("/healthz", auth), ...)
+//
+// Unless panics if pattern is malformed.
+func Unless(pattern string, mw Middleware) Middleware {
+	return matchPath(pattern, mw, false)
+}
+
+// OnlyFor returns a Middleware applying mw only to requests whose path
+// matches pattern, as reported by path.Match. Note that '*' does not match
+// '/', so "/static/*" matches "/static/a.css" but not "/static/css/a.css".
+//
+// OnlyFor panics if pattern is malformed.
+func OnlyFor(pattern string, mw Middleware) Middleware {
+	return matchPath(pattern, mw, true)
+}
+
+// Beneath returns a Middleware applying mw only to requests for prefix or
+// a path in the subtree beneath it. For example, to protect "/private" and
+// everything under "/private/":
+//
+// Apply(Beneath("/private", BasicAuth(realm, validator)), ...)
+func Beneath(prefix string, mw Middleware) Middleware {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(h http.Handler) http.Handler {
+		wrapped := mw(h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rest, ok := strings.CutPrefix(r.URL.Path, prefix); ok && (rest == "" || rest[0] == '/') {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func matchPath(pattern string, mw Middleware, want bool) Middleware {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(fmt.Sprintf("path pattern %q: %v", pattern, err))
+	}
+	return func(h http.Handler) http.Handler {
+		wrapped := mw(h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, _ := path.Match(pattern, r.URL.Path); ok == want {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Recover is a middleware responding 500 Internal Server Error to requests
+// whose handler panics. The panic is logged with the request UUID, set by Log,
+// and the stack trace indented beneath it, or, if the LogBackend is a
+// SlogBackend, passed to its handler as a record at level Error with "ident"
+// and "stack" attributes.
 func Recover(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
 				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				logger.Printf("panic(%): %v\n", err, r.Context())
-				fmt.Println(string(debug.Stack()))
+				id := "-"
+				if uuid, ok := r.Context().Value("uuid").(UUID); ok {
+					id = uuid.String()
+				}
+				if b, ok := logBackend.(SlogBackend); ok {
+					rec := slog.NewRecord(time.Now(), slog.LevelError, fmt.Sprintf("panic: %v", err), 0)
+					rec.AddAttrs(
+						slog.String("ident", id),
+						slog.String("stack", string(debug.Stack())),
+					)
+					b.Handler.Handle(r.Context(), rec)
+					return
+				}
+				logger.Printf("panic: %v (request %s)\n%s", err, id, indent(debug.Stack()))
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
+// indent returns b with each line indented by a tab.
+func indent(b []byte) string {
+	lines := strings.Split(strings.TrimRight(string(b), "\n"), "\n")
+	return "\t" + strings.Join(lines, "\n\t")
+}
+
 // UUID is a universally unique identifier as defined in RFC 4122.
 type UUID [16]byte
 
@@ -70,44 +294,191 @@ func NewV4UUID(r io.Reader) (UUID, error) {
 	return u, nil
 }
 
+// NewV7UUIDRandom returns a version 7 UUID for the current time.
+func NewV7UUIDRandom() (UUID, error) {
+	return NewV7UUID(time.Now(), rander)
+}
+
+// NewV7UUID returns a version 7 UUID (RFC 9562, 5.7) for t, with random bits
+// read from r. Version 7 UUIDs sort by creation time: the 48-bit Unix
+// timestamp in milliseconds is followed by 12 bits of sub-millisecond
+// precision (RFC 9562, 6.2, method 3).
+func NewV7UUID(t time.Time, r io.Reader) (UUID, error) {
+	var u UUID
+	if _, err := io.ReadFull(r, u[8:]); err != nil {
+		return u, err
+	}
+	ms := uint64(t.UnixMilli())
+	frac := uint64(t.Nanosecond()%1e6) * 4096 / 1e6
+	u[0] = byte(ms >> 40)
+	u[1] = byte(ms >> 32)
+	u[2] = byte(ms >> 24)
+	u[3] = byte(ms >> 16)
+	u[4] = byte(ms >> 8)
+	u[5] = byte(ms)
+	u[6] = 0x70 | byte(frac>>8) // RFC 9562, 4.2
+	u[7] = byte(frac)
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 9562, 4.1
+	return u, nil
+}
+
+// NewRequestID returns the UUID identifying each request logged. It may be
+// set to NewV7UUIDRandom for request IDs ordered by time.
+var NewRequestID = NewV4UUIDRandom
+
 func (uuid UUID) String() string {
 	var buf [36]byte
-	hex.Encode(buf[:], uuid[:4])
-	buf[8] = '-'
-	hex.Encode(buf[9:13], uuid[4:6])
-	buf[13] = '-'
-	hex.Encode(buf[14:18], uuid[6:8])
-	buf[18] = '-'
-	hex.Encode(buf[19:23], uuid[8:10])
-	buf[23] = '-'
-	hex.Encode(buf[24:], uuid[10:])
+	return string(uuid.AppendTo(buf[:0]))
+}
+
+// AppendTo appends the canonical hyphenated form of uuid to buf and returns
+// the extended buffer, so that callers may reuse buffers.
+func (uuid UUID) AppendTo(buf []byte) []byte {
+	var b [36]byte
+	hex.Encode(b[:], uuid[:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], uuid[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], uuid[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], uuid[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], uuid[10:])
+
+	return append(buf, b[:]...)
+}
 
-	return string(buf[:])
+// MarshalText implements encoding.TextMarshaler, returning the canonical
+// hyphenated form.
+func (uuid UUID) MarshalText() ([]byte, error) {
+	return []byte(uuid.String()), nil
 }
 
+// UnmarshalText implements encoding.TextUnmarshaler, accepting the forms
+// accepted by ParseUUID.
+func (uuid *UUID) UnmarshalText(b []byte) error {
+	u, err := ParseUUID(string(b))
+	if err != nil {
+		return err
+	}
+	*uuid = u
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding uuid as a string.
+func (uuid UUID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(uuid.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (uuid *UUID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return uuid.UnmarshalText([]byte(s))
+}
+
+// ParseUUID parses a UUID in the canonical hyphenated form, such as
+// "f47ac10b-58cc-4372-a567-0e02b2c3d479", or as 32 hexadecimal digits.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	switch len(s) {
+	case 36:
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return u, fmt.Errorf("invalid UUID %q", s)
+		}
+		s = s[:8] + s[9:13] + s[14:18] + s[19:23] + s[24:]
+	case 32:
+	default:
+		return u, fmt.Errorf("invalid UUID length %d", len(s))
+	}
+	if _, err := hex.Decode(u[:], []byte(s)); err != nil {
+		return UUID{}, fmt.Errorf("invalid UUID: %v", err)
+	}
+	return u, nil
+}
+
+// NewRequestContext returns the context of r with the request UUID from the
+// uuid header or, if absent, from NewRequestID.
 func NewRequestContext(r *http.Request) context.Context {
-	var uuid UUID
-	var err error
-	if rid := r.Header.Get("uuid"); rid == "" {
-		if uuid, err = NewV4UUIDRandom(); err != nil {
+	return NewRequestContextWith(r, NewRequestID)
+}
+
+// NewRequestContextWith is like NewRequestContext, but generates the request
+// UUID with newID, e.g. to make request IDs deterministic in tests.
+func NewRequestContextWith(r *http.Request, newID func() (UUID, error)) context.Context {
+	uuid, err := ParseUUID(r.Header.Get("uuid"))
+	if err != nil {
+		if uuid, err = newID(); err != nil {
 			logger.Printf("UUID: %v\n", err)
 		}
 	}
 	return context.WithValue(r.Context(), "uuid", uuid)
 }
 
+// NewRequestContextBudget is like NewRequestContextWith, but the context is
+// also cancelled once budget has elapsed, if positive, so that handlers and
+// their outbound calls may respect the deadline. The cancel function must be
+// called when the request has been served, to release its resources.
+func NewRequestContextBudget(r *http.Request, newID func() (UUID, error), budget time.Duration) (context.Context, context.CancelFunc) {
+	ctx := NewRequestContextWith(r, newID)
+	if budget > 0 {
+		return context.WithTimeout(ctx, budget)
+	}
+	return context.WithCancel(ctx)
+}
+
+type contextKey int
+
+const (
+	clientCNKey contextKey = iota
+	principalKey
+	middlewareNamesKey
+)
+
+// ClientIdentity is a middleware storing the common name of a verified TLS
+// client certificate in the request context. See ClientCNFromContext.
+func ClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cn := clientCN(r); cn != "" {
+			r = r.WithContext(context.WithValue(r.Context(), clientCNKey, cn))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientCNFromContext returns the common name of the verified client
+// certificate stored by ClientIdentity, or the empty string.
+func ClientCNFromContext(ctx context.Context) string {
+	cn, _ := ctx.Value(clientCNKey).(string)
+	return cn
+}
+
+// clientCN returns the common name of the verified client certificate
+// presented with r, if any.
+func clientCN(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
 type CLFEntry struct {
-	addr     string    // Client network address
-	userID   string    // User ID
-	ident    string    // RFC 1413 client identity
-	ts       time.Time // Timestamp of the start of the request
-	method   string    // Request method
-	path     string    //
-	proto    string    // Protocol
-	status   int       // Status code
-	size     int       // Size (in bytes) returned to the client
-	ua       string    // Client user agent
-	referrer string    // Referrer header (spelt correctly)
+	addr     string      // Client network address
+	userID   string      // User ID
+	ident    UUID        // Request UUID, in place of the RFC 1413 identity
+	ts       time.Time   // Timestamp of the start of the request
+	method   string      // Request method
+	path     string      // Escaped request path, and query if redacted
+	proto    string      // Protocol
+	status   int         // Status code
+	size     int         // Size (in bytes) returned to the client
+	ua       string      // Client user agent
+	referrer string      // Referrer header (spelt correctly)
+	header   http.Header // Response headers, if recorded for debugging
+
+	Duration time.Duration // Time taken to serve the request
 }
 
 // NewCLFEntry returns a structure representing a signle combined log format
@@ -119,10 +490,10 @@ func NewCLFEntry(r *http.Request, uuid UUID) *CLFEntry {
 	l := &CLFEntry{
 		addr:     "-",
 		userID:   "-",
-		ident:    uuid.String(),
+		ident:    uuid,
 		ts:       time.Now(),
 		method:   r.Method,
-		path:     r.URL.Path,
+		path:     r.URL.EscapedPath(),
 		proto:    r.Proto,
 		status:   0,
 		size:     0,
@@ -138,6 +509,8 @@ func NewCLFEntry(r *http.Request, uuid UUID) *CLFEntry {
 	}
 	if u, _, ok := r.BasicAuth(); ok {
 		l.userID = u
+	} else if cn := clientCN(r); cn != "" {
+		l.userID = cn
 	}
 	if addr, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
 		l.addr = addr
@@ -157,25 +530,90 @@ const (
 
 // String returns a string representation of CLFLogEntry in Combined Log Format.
 func (c *CLFEntry) String() string {
-	status := func(code int) string {
-		if http.StatusText(code) == "" {
-			return "-"
-		}
-		return fmt.Sprintf("%d", code)
-	}
-
-	return fmt.Sprintf(CombinedLogFormat,
-		c.addr,
-		c.userID,
-		c.ident,
-		c.ts.Format("02/Jan/2006:15:04:05 -0700"),
-		fmt.Sprintf("\"%s %s\"", c.method, c.path),
-		c.proto,
-		status(c.status),
-		c.size,
-		c.ua,
-		c.referrer,
+	var buf [256]byte
+	return string(c.appendTo(buf[:0]))
+}
+
+// logBufPool holds the buffers used by CLFEntry.Write.
+var logBufPool = sync.Pool{New: func() any { b := make([]byte, 0, 512); return &b }}
+
+// Write writes c to w in Combined Log Format, followed by a newline, in a
+// single call to w.Write. Unlike String, it does not allocate: the entry is
+// formatted in a pooled buffer.
+func (c *CLFEntry) Write(w io.Writer) error {
+	bp := logBufPool.Get().(*[]byte)
+	b := append(c.appendTo((*bp)[:0]), '\n')
+	_, err := w.Write(b)
+	*bp = b
+	logBufPool.Put(bp)
+	return err
+}
+
+// appendTo appends c to b in the form of CombinedLogFormat.
+func (c *CLFEntry) appendTo(b []byte) []byte {
+	b = append(b, c.addr...)
+	b = append(b, ' ')
+	b = append(b, c.userID...)
+	b = append(b, ' ')
+	b = c.ident.AppendTo(b)
+	b = append(b, " ["...)
+	b = c.ts.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, "] \""...)
+	b = append(b, c.method...)
+	b = append(b, ' ')
+	b = append(b, c.path...)
+	b = append(b, "\" "...)
+	b = append(b, c.proto...)
+	b = append(b, ' ')
+	if http.StatusText(c.status) == "" {
+		b = append(b, '-')
+	} else {
+		b = strconv.AppendInt(b, int64(c.status), 10)
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, int64(c.size), 10)
+	b = append(b, " \""...)
+	b = append(b, c.ua...)
+	b = append(b, "\" \""...)
+	b = append(b, c.referrer...)
+	return append(b, '"')
+}
+
+// Header returns the response headers recorded for debugging, as set by
+// LogOptions.ResponseHeaders, or nil.
+func (c *CLFEntry) Header() http.Header {
+	return c.header
+}
+
+// SlogRecord returns c as a structured log record at level Info.
+func (c *CLFEntry) SlogRecord() slog.Record {
+	r := slog.NewRecord(c.ts, slog.LevelInfo, "request", 0)
+	r.AddAttrs(
+		slog.String("addr", c.addr),
+		slog.String("user", c.userID),
+		slog.String("ident", c.ident.String()),
+		slog.String("method", c.method),
+		slog.String("path", c.path),
+		slog.String("proto", c.proto),
+		slog.Int("status", c.status),
+		slog.Int64("bytes", int64(c.size)),
+		slog.String("user_agent", c.ua),
+		slog.String("referrer", c.referrer),
+		slog.Duration("duration", c.Duration),
 	)
+	if c.header != nil {
+		keys := make([]string, 0, len(c.header))
+		for k := range c.header {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		attrs := make([]any, len(keys))
+		for i, k := range keys {
+			attrs[i] = slog.String(k, strings.Join(c.header[k], ", "))
+		}
+		r.AddAttrs(slog.Group("headers", attrs...))
+	}
+	return r
 }
 
 type statusRecorder struct {
@@ -184,41 +622,401 @@ type statusRecorder struct {
 	size   int
 }
 
+// statusRecorderPool holds statusRecorders for reuse by Log, reducing
+// allocations per request.
+var statusRecorderPool = sync.Pool{New: func() any { return &statusRecorder{} }}
+
+// getStatusRecorder returns a statusRecorder from the pool wrapping w.
+func getStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	rec := statusRecorderPool.Get().(*statusRecorder)
+	rec.ResponseWriter, rec.status, rec.size = w, 200, 0
+	return rec
+}
+
+// putStatusRecorder returns rec to the pool, once the handler it was passed to
+// has returned.
+func putStatusRecorder(rec *statusRecorder) {
+	*rec = statusRecorder{}
+	statusRecorderPool.Put(rec)
+}
+
+// WriteHeader records code unless it is informational (1xx), as then the
+// final status is still to come.
 func (rec *statusRecorder) WriteHeader(code int) {
-	rec.status = code
+	if code >= 200 {
+		rec.status = code
+	}
 	rec.ResponseWriter.WriteHeader(code)
 }
 
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+// Flush sends buffered data to the client, if supported by the underlying
+// ResponseWriter, so that streaming handlers work through Log.
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the caller take over the connection, if supported by the
+// underlying ResponseWriter.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hj.Hijack()
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// activeConns is the number of requests being served by MaxConnections.
+var activeConns atomic.Int64
+
+// ActiveConnections returns the number of requests currently being served
+// through MaxConnections middlewares.
+func ActiveConnections() int {
+	return int(activeConns.Load())
+}
+
+// MaxConnections returns a middleware serving at most n requests
+// concurrently. Further requests are refused with 503 Service Unavailable
+// and a Retry-After header.
+func MaxConnections(n int) func(http.Handler) http.Handler {
+	sem := make(chan struct{}, n)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+			default:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+			activeConns.Add(1)
+			defer func() {
+				activeConns.Add(-1)
+				<-sem
+			}()
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Reject0RTT returns a middleware responding 425 Too Early (RFC 8470) to
+// requests with side effects received in TLS early data, which an attacker
+// may replay. Requests are considered early if the TLS handshake was not
+// complete when the request was received, as reported by the HTTP/3 server
+// for 0-RTT requests, or if a proxy marked them with "Early-Data: 1".
+// Reject0RTT is required when serving HTTP/3 with 0-RTT enabled.
+func Reject0RTT() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			early := (r.TLS != nil && !r.TLS.HandshakeComplete) || r.Header.Get("Early-Data") == "1"
+			switch r.Method {
+			case "GET", "HEAD", "OPTIONS":
+			default:
+				if early {
+					http.Error(w, http.StatusText(http.StatusTooEarly), http.StatusTooEarly)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// FlushMiddleware returns a middleware flushing the response, if the
+// ResponseWriter supports it, once the handler returns, so that buffered and
+// chunked responses are sent even if an inner middleware did not flush. It is
+// intended to be the outermost middleware:
+//
+// Apply(FlushMiddleware(), ...)
+func FlushMiddleware() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(w, r)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		})
+	}
+}
+
+// DefaultTimeoutMessage is the body of responses to requests timed out by
+// Timeout.
+const DefaultTimeoutMessage = "Service Unavailable: request timed out"
+
+// Timeout returns a middleware responding 503 Service Unavailable with
+// DefaultTimeoutMessage to requests whose handler has not finished within d,
+// independently of the server's WriteTimeout.
+func Timeout(d time.Duration) Middleware {
+	return TimeoutMessage(d, DefaultTimeoutMessage)
+}
+
+// TimeoutMessage is like Timeout, responding to timed out requests with msg.
+// As the response is buffered until the handler returns, it is unsuitable for
+// streaming handlers: see http.TimeoutHandler.
+func TimeoutMessage(d time.Duration, msg string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.TimeoutHandler(h, d, msg)
+	}
+}
+
+// AllowIPs returns a middleware responding 403 Forbidden to requests from
+// client addresses outside the given CIDR ranges, such as "127.0.0.1/32".
+func AllowIPs(cidrs ...string) (Middleware, error) {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if remoteIn(r, nets) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}, nil
+}
+
+// parseCIDRs parses CIDR ranges such as "127.0.0.1/32".
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(c))
+		if err != nil {
+			return nil, err
+		}
+		nets[i] = n
+	}
+	return nets, nil
+}
+
+// remoteIn reports whether the client address of r is in one of nets.
+func remoteIn(r *http.Request, nets []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LogOptions configures the Log middleware.
+type LogOptions struct {
+	// SlowRequestThreshold is the duration after which a request is
+	// considered slow. Zero selects DefaultSlowRequestThreshold.
+	SlowRequestThreshold time.Duration
+
+	// SlowRequestHook, if non-nil, is called for each slow request in place
+	// of logging it, e.g. to raise an alert or increment a metric.
+	SlowRequestHook func(entry *CLFEntry, took time.Duration)
+
+	// ExcludePaths lists request paths which are not logged, such as
+	// health checks.
+	ExcludePaths []string
+
+	// RequestID, if non-nil, generates request UUIDs in place of
+	// NewRequestID.
+	RequestID func() (UUID, error)
+
+	// RedactParams lists query parameters, such as "token", whose values
+	// are replaced with REDACTED in logged request URLs. If empty, the query
+	// is not logged.
+	RedactParams []string
+
+	// ResponseHeaders, if set, records the response headers in each entry
+	// for debugging. They are available to a LogBackend through
+	// CLFEntry.Header, and in the "headers" group of CLFEntry.SlogRecord,
+	// but are not written in Combined Log Format.
+	ResponseHeaders bool
+
+	// RedactHeaders lists response headers, such as Set-Cookie, which are
+	// omitted from those recorded by ResponseHeaders.
+	RedactHeaders []string
+
+	// RequestBudget, if positive, is the time allowed to serve each
+	// request, after which its context is cancelled. See
+	// NewRequestContextBudget.
+	RequestBudget time.Duration
+
+	// SampleRate, if greater than 1, logs only one in SampleRate of the
+	// requests answered with a 1xx, 2xx or 3xx status, to reduce log volume.
+	// Errors are always logged, as are slow requests.
+	SampleRate int
+}
+
+// DefaultLogOptions are the options used by Log.
+var DefaultLogOptions = LogOptions{
+	ExcludePaths: []string{DefaultHealthPath, DefaultReadyPath},
+}
+
+// DefaultSlowRequestThreshold is the duration after which requests are logged
+// as slow. Server response times should generally be <200ms.
+const DefaultSlowRequestThreshold = 200 * time.Millisecond
+
 // Log is a middleware that logs the start and end of a request in CLF format.
 // Log should be used before other middlewares when used with Apply.
 func Log(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := NewRequestContext(r)
-		uuid, ok := ctx.Value("uuid").(UUID)
-		if !ok {
-			logger.Println("malformed uuid in request context")
+	return LogWith(DefaultLogOptions)(next)
+}
+
+// LogWith returns a Log middleware configured by opts.
+func LogWith(opts LogOptions) Middleware {
+	threshold := opts.SlowRequestThreshold
+	if threshold == 0 {
+		threshold = DefaultSlowRequestThreshold
+	}
+	newID := opts.RequestID
+	if newID == nil {
+		newID = NewRequestID
+	}
+	exclude := make(map[string]bool, len(opts.ExcludePaths))
+	for _, p := range opts.ExcludePaths {
+		exclude[p] = true
+	}
+	// sampled counts successful requests which are not slow, so that every
+	// SampleRate'th is logged.
+	var sampled atomic.Uint64
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if exclude[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := NewRequestContextBudget(r, newID, opts.RequestBudget)
+			defer cancel()
+			uuid, ok := ctx.Value("uuid").(UUID)
+			if !ok {
+				logger.Println("malformed uuid in request context")
+			}
+			wr := getStatusRecorder(w)
+			defer putStatusRecorder(wr)
+			l := NewCLFEntry(r, uuid)
+			if len(opts.RedactParams) > 0 {
+				l.redact(r, opts.RedactParams)
+			}
+			next.ServeHTTP(wr, r.WithContext(ctx))
+			if opts.ResponseHeaders {
+				l.header = w.Header().Clone()
+				for _, h := range opts.RedactHeaders {
+					l.header.Del(h)
+				}
+			}
+
+			t1 := time.Now()
+			l.status = wr.status
+			l.size = wr.size
+			l.Duration = t1.Sub(l.ts)
+			slow := l.Duration >= threshold
+			if l.status >= 400 || slow || opts.SampleRate <= 1 || sampled.Add(1)%uint64(opts.SampleRate) == 1 {
+				if logBackend != nil {
+					logBackend.Log(l)
+				} else if err := l.Write(accessLog().Writer()); err != nil {
+					logger.Printf("log: %v", err)
+				}
+			}
+
+			if !slow {
+				return
+			}
+			if opts.SlowRequestHook != nil {
+				opts.SlowRequestHook(l, l.Duration)
+				return
+			}
+			logger.Printf("slow request: %x (took: %v)\n", uuid, l.Duration)
+		})
+	}
+}
+
+// RedactedLog returns a Log middleware which replaces the values of the given
+// query parameters with REDACTED in log entries, and records the response
+// headers for debugging, as by LogOptions.ResponseHeaders, except for
+// redactHeaders.
+func RedactedLog(redactParams, redactHeaders []string) Middleware {
+	opts := DefaultLogOptions
+	opts.RedactParams = redactParams
+	opts.ResponseHeaders = true
+	opts.RedactHeaders = redactHeaders
+	return LogWith(opts)
+}
+
+// redact sets the path of c to the URL of r with the values of params
+// redacted, including in the referrer.
+func (c *CLFEntry) redact(r *http.Request, params []string) {
+	c.path = redactURL(r.URL, params)
+	if c.referrer != "-" {
+		if u, err := url.Parse(c.referrer); err == nil {
+			ref := *u
+			ref.RawQuery = redactQuery(u.Query(), params)
+			c.referrer = ref.String()
 		}
-		wr := &statusRecorder{w, 200, 0}
-		l := NewCLFEntry(r, uuid)
-		next.ServeHTTP(wr, r.WithContext(ctx))
-
-		t1 := time.Now()
-		l.status = wr.status
-		l.size = wr.size
-		logger.Println(l)
-
-		// Server response times should generally be <200ms
-		took := t1.Sub(l.ts)
-		if took/1000 >= 200 {
-			logger.Printf("slow request: %x (took: %v)\n", uuid, took)
+	}
+}
+
+// redactURL returns the escaped path and query of u, with the values of
+// params replaced with REDACTED.
+func redactURL(u *url.URL, params []string) string {
+	if u.RawQuery == "" {
+		return u.EscapedPath()
+	}
+	return u.EscapedPath() + "?" + redactQuery(u.Query(), params)
+}
+
+func redactQuery(q url.Values, params []string) string {
+	for _, p := range params {
+		if vs, ok := q[p]; ok {
+			for i := range vs {
+				vs[i] = "REDACTED"
+			}
 		}
-	})
+	}
+	// Encode escapes the query, as EscapedPath does the path, so that
+	// neither can forge log fields.
+	return q.Encode()
 }
 
-func middleware(mux *http.ServeMux) http.Handler {
-	mw := Apply(
+// DefaultMiddleware returns the middleware chain applied by NewHandler when
+// none is given. Requests are logged, to the access logger or LogBackend, and
+// panics recovered, before the security policies are applied.
+func DefaultMiddleware() []Middleware {
+	return []Middleware{
+		LogWith(DefaultLogOptions),
+		Recover,
 		SecureHeaders(),
-		AcceptHeaders(),
-	)
-	return mw(mux)
+		AcceptHeadersWith(AcceptOptions{}),
+		ClientIdentity,
+	}
+}
+
+// NewHandler returns h wrapped by the middleware chain m, applied in order as
+// by Apply, or by DefaultMiddleware if m is empty. To extend the default
+// chain, append to DefaultMiddleware:
+//
+// NewHandler(mux, append(DefaultMiddleware(), FlushMiddleware())...)
+func NewHandler(h http.Handler, m ...Middleware) http.Handler {
+	if len(m) == 0 {
+		m = DefaultMiddleware()
+	}
+	return Apply(m...)(h)
 }
@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mark is a middleware setting the X-Mark response header.
+func mark(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Mark", "1")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestPathMatch(t *testing.T) {
+	tests := []struct {
+		mw     Middleware
+		path   string
+		marked bool
+	}{
+		{OnlyFor("/admin", mark), "/admin", true},
+		{OnlyFor("/admin", mark), "/admin/x", false},
+		{OnlyFor("/static/*", mark), "/static/a.css", true},
+		{OnlyFor("/static/*", mark), "/static/css/a.css", false},
+		{OnlyFor("/static/*", mark), "/index.html", false},
+		{Unless("/healthz", mark), "/healthz", false},
+		{Unless("/healthz", mark), "/index.html", true},
+		{Unless("/static/*", mark), "/static/a.css", false},
+	}
+
+	for _, tt := range tests {
+		h := Apply(tt.mw)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if got := w.Header().Get("X-Mark") != ""; got != tt.marked {
+			t.Errorf("%s: middleware applied = %v, want %v", tt.path, got, tt.marked)
+		}
+	}
+}
+
+func TestPathMatchBadPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic for malformed pattern")
+		}
+	}()
+	OnlyFor("[", mark)
+}
+
+func TestNewHandlerChain(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), record("gzip"), record("cors"), record("ratelimit"))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	want := []string{"gzip", "cors", "ratelimit", "handler"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %q, want %q", order, want)
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	})
+
+	Apply(record("log"), record("auth"), record("gzip"))(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	want := order
+	order = nil
+
+	var c Chain
+	c.Use(record("log"))
+	for _, enabled := range []string{"auth", "gzip"} {
+		c.Use(record(enabled))
+	}
+	c.Then(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("Chain order = %q, Apply order = %q", order, want)
+	}
+}
+
+func TestSetLogOutput(t *testing.T) {
+	var buf bytes.Buffer
+	defer SetLogOutput(logger.Writer())
+	SetLogOutput(&buf)
+
+	h := Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/index.html", nil))
+	if !strings.Contains(buf.String(), `"GET /index.html"`) {
+		t.Errorf("request not logged to buffer: %q", buf.String())
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	defer SetLogger(logger)
+	SetLogger(log.New(&buf, "test: ", 0))
+
+	h := Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(buf.String(), `"GET /"`) {
+		t.Errorf("request not logged by the logger set: %q", buf.String())
+	}
+}
+
+func TestApplyNamed(t *testing.T) {
+	var got []string
+	h := ApplyNamed(
+		NamedLayer{"secure", SecureHeaders()},
+		NamedLayer{"mark", mark},
+		NamedLayer{"log", Log},
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = MiddlewareNames(r.Context())
+	}))
+
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+	r := httptest.NewRequest("GET", "https://bwsd.net/", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if want := []string{"secure", "mark", "log"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MiddlewareNames = %q, want %q", got, want)
+	}
+	if names := MiddlewareNames(r.Context()); names != nil {
+		t.Errorf("names recorded in the original request: %q", names)
+	}
+
+	h = Apply(NamedMiddleware("outer", mark), mark)(NamedMiddleware("inner", Log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = MiddlewareNames(r.Context())
+	})))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "https://bwsd.net/", nil))
+	if want := []string{"outer", "inner"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MiddlewareNames = %q, want %q", got, want)
+	}
+}
+
+func TestLogFlusher(t *testing.T) {
+	h := Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatalf("%T is not an http.Flusher", w)
+		}
+		w.Write([]byte("data: 1\n\n"))
+		f.Flush()
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/events", nil))
+	if !w.Flushed {
+		t.Errorf("underlying writer not flushed")
+	}
+}
+
+func TestCLFEntrySlogRecord(t *testing.T) {
+	r := httptest.NewRequest("POST", "/upload", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "test-agent")
+	r.Header.Set("Referer", "https://example.com/")
+	r.SetBasicAuth("alice", "secret")
+	e := NewCLFEntry(r, UUID{})
+	e.status = http.StatusCreated
+	e.size = 42
+	e.Duration = 3 * time.Millisecond
+
+	rec := e.SlogRecord()
+	if !rec.Time.Equal(e.ts) || rec.Level != slog.LevelInfo {
+		t.Errorf("got time %v level %v, want %v %v", rec.Time, rec.Level, e.ts, slog.LevelInfo)
+	}
+	got := make(map[string]any)
+	rec.Attrs(func(a slog.Attr) bool {
+		got[a.Key] = a.Value.Any()
+		return true
+	})
+	want := map[string]any{
+		"addr":       "192.0.2.1",
+		"user":       "alice",
+		"ident":      UUID{}.String(),
+		"method":     "POST",
+		"path":       "/upload",
+		"proto":      "HTTP/1.1",
+		"status":     int64(http.StatusCreated),
+		"bytes":      int64(42),
+		"user_agent": "test-agent",
+		"referrer":   "https://example.com/",
+		"duration":   3 * time.Millisecond,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got attrs %v, want %v", got, want)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := slog.NewJSONHandler(&buf, nil)
+	SlogLogger(h).Print("hello")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if err := h.Handle(context.Background(), NewCLFEntry(r, UUID{}).SlogRecord()); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %q", len(lines), buf.String())
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil || m["msg"] != "hello" {
+		t.Errorf("got %q, want msg hello", lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &m); err != nil || m["method"] != "GET" {
+		t.Errorf("got %q, want method GET", lines[1])
+	}
+}
+
+func TestRedactedLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	h := RedactedLog([]string{"token"}, []string{"set-cookie"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t"})
+		w.Header().Set("X-Debug", "yes")
+		http.NotFound(w, r)
+	}))
+	r := httptest.NewRequest("GET", "/reset?token=s3cr3t&lang=en", nil)
+	r.Header.Set("Referer", "https://example.com/login?token=s3cr3t")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	got := buf.String()
+	if strings.Contains(got, "s3cr3t") {
+		t.Errorf("log entry not redacted: %q", got)
+	}
+	for _, want := range []string{
+		`"GET /reset?lang=en&token=REDACTED"`,
+		`"https://example.com/login?token=REDACTED"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log entry %q does not contain %q", got, want)
+		}
+	}
+
+	var entries entryRecorder
+	SetLogBackend(&entries)
+	defer SetLogBackend(nil)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if len(entries) != 1 {
+		t.Fatalf("backend received %d entries, want 1", len(entries))
+	}
+	hdr := entries[0].Header()
+	if hdr.Get("X-Debug") != "yes" || hdr.Get("Set-Cookie") != "" {
+		t.Errorf("recorded response headers %v, want X-Debug without Set-Cookie", hdr)
+	}
+	var found bool
+	entries[0].SlogRecord().Attrs(func(a slog.Attr) bool {
+		found = found || (a.Key == "headers" && strings.Contains(a.Value.String(), "X-Debug"))
+		return true
+	})
+	if !found {
+		t.Errorf("slog record has no headers group")
+	}
+}
+
+func TestLogEscapesPath(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	for _, h := range []http.Handler{
+		Log(http.NotFoundHandler()),
+		RedactedLog([]string{"token"}, nil)(http.NotFoundHandler()),
+	} {
+		buf.Reset()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = `/a" 200 0 "forged`
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got := buf.String(); !strings.Contains(got, `"GET /a%22%20200%200%20%22forged"`) {
+			t.Errorf("path not escaped: %q", got)
+		}
+	}
+}
+
+func TestReject0RTT(t *testing.T) {
+	h := Reject0RTT()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		method string
+		early  bool
+		want   int
+	}{
+		{"GET", true, http.StatusOK},
+		{"POST", true, http.StatusTooEarly},
+		{"POST", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "https://example.com/", nil)
+		r.TLS.HandshakeComplete = !tt.early
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s early=%v: got status %d, want %d", tt.method, tt.early, w.Code, tt.want)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	h := Apply(Log, Recover)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	lines := strings.Split(buf.String(), "\n")
+	first := lines[0]
+	if !strings.HasPrefix(first, "site: panic: boom (request ") || strings.Contains(first, "(request -)") {
+		t.Fatalf("got first line %q, want panic value and request UUID", first)
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(first, "site: panic: boom (request "), ")")
+	if !strings.Contains(buf.String(), " "+id+" ") {
+		t.Errorf("request UUID %s not in access log: %q", id, buf.String())
+	}
+	if !strings.HasPrefix(lines[1], "\tgoroutine ") {
+		t.Errorf("stack trace not indented: %q", lines[1])
+	}
+}
+
+func TestRecoverSlog(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogBackend(SlogBackend{slog.NewJSONHandler(&buf, nil)})
+	defer SetLogBackend(nil)
+
+	h := Apply(Log, Recover)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d records, want panic and request: %q", len(lines), buf.String())
+	}
+	var panicRec, reqRec map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &panicRec); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &reqRec); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if panicRec["level"] != "ERROR" || panicRec["msg"] != "panic: boom" {
+		t.Errorf("got panic record %v, want level ERROR and msg %q", panicRec, "panic: boom")
+	}
+	if id, _ := panicRec["ident"].(string); id == "" || id == "-" || id != reqRec["ident"] {
+		t.Errorf("panic ident %v does not match request ident %v", panicRec["ident"], reqRec["ident"])
+	}
+	if stack, _ := panicRec["stack"].(string); !strings.HasPrefix(stack, "goroutine ") {
+		t.Errorf("got stack %q, want goroutine trace", stack)
+	}
+	if reqRec["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("got request status %v, want 500", reqRec["status"])
+	}
+}
+
+func TestNewV7UUID(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var prev UUID
+	for i, d := range []time.Duration{0, time.Microsecond, time.Millisecond, time.Second, time.Hour} {
+		u, err := NewV7UUID(t0.Add(d), rand.Reader)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if v := u[6] >> 4; v != 7 {
+			t.Errorf("%v: version = %d, want 7", u, v)
+		}
+		if variant := u[8] >> 6; variant != 0b10 {
+			t.Errorf("%v: variant = %b, want 10", u, variant)
+		}
+		if i > 0 && bytes.Compare(prev[:], u[:]) >= 0 {
+			t.Errorf("%v does not sort after %v", u, prev)
+		}
+		prev = u
+	}
+
+	u, _ := NewV7UUID(t0, rand.Reader)
+	if got, want := u.String()[:13], "018f3406-9e00"; got != want {
+		t.Errorf("timestamp = %s, want %s", got, want)
+	}
+}
+
+func TestParseUUID(t *testing.T) {
+	const canonical = "f47ac10b-58cc-4372-a567-0e02b2c3d479"
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{canonical, false},
+		{"F47AC10B-58CC-4372-A567-0E02B2C3D479", false},
+		{"f47ac10b58cc4372a5670e02b2c3d479", false},
+		{"", true},
+		{"f47ac10b-58cc-4372-a567-0e02b2c3d47", true},
+		{"f47ac10b-58cc-4372-a567-0e02b2c3d4799", true},
+		{"f47ac10bx58cc-4372-a567-0e02b2c3d479", true},
+		{"g47ac10b-58cc-4372-a567-0e02b2c3d479", true},
+		{"f47ac10b-58cc-4372-a567-0e02b2c3d47z", true},
+	}
+	for _, tt := range tests {
+		u, err := ParseUUID(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseUUID(%q): got error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && u.String() != canonical {
+			t.Errorf("ParseUUID(%q) = %s, want %s", tt.in, u, canonical)
+		}
+	}
+
+	u, err := NewV4UUIDRandom()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	v, err := ParseUUID(u.String())
+	if err != nil || v != u || v.String() != u.String() {
+		t.Errorf("round trip of %s: got %s, %v", u, v, err)
+	}
+}
+
+func TestUUIDMarshal(t *testing.T) {
+	const s = "f47ac10b-58cc-4372-a567-0e02b2c3d479"
+	u, err := ParseUUID(s)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	type entry struct {
+		ID UUID `json:"id"`
+	}
+	b, err := json.Marshal(entry{u})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if want := `{"id":"` + s + `"}`; string(b) != want {
+		t.Errorf("json.Marshal = %s, want %s", b, want)
+	}
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil || e.ID != u {
+		t.Errorf("json.Unmarshal = %s, %v, want %s", e.ID, err, u)
+	}
+
+	text, err := u.MarshalText()
+	if err != nil || string(text) != s {
+		t.Errorf("MarshalText = %s, %v, want %s", text, err, s)
+	}
+	var v UUID
+	if err := v.UnmarshalText(text); err != nil || v != u {
+		t.Errorf("UnmarshalText = %s, %v, want %s", v, err, u)
+	}
+
+	for _, in := range []string{`{"id":"not-a-uuid"}`, `{"id":42}`} {
+		if err := json.Unmarshal([]byte(in), &e); err == nil {
+			t.Errorf("json.Unmarshal(%s): expected error", in)
+		}
+	}
+	if err := v.UnmarshalText([]byte("f47ac10b")); err == nil {
+		t.Errorf("UnmarshalText: expected error for malformed UUID")
+	}
+}
+
+func TestLogRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	fixed := func() (UUID, error) {
+		return NewV4UUID(bytes.NewReader(bytes.Repeat([]byte{0xab}, 16)))
+	}
+	const want = "abababab-abab-4bab-abab-abababababab"
+
+	var got UUID
+	h := LogWith(LogOptions{RequestID: fixed})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = r.Context().Value("uuid").(UUID)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if got.String() != want {
+		t.Errorf("request UUID = %s, want %s", got, want)
+	}
+	if !strings.Contains(buf.String(), " "+want+" ") {
+		t.Errorf("log entry %q does not contain %s", buf.String(), want)
+	}
+}
+
+func TestLogReusesStatusRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	h := Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	for _, p := range []string{"/missing", "/", "/missing", "/"} {
+		buf.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+		want := " 200 2 "
+		if p == "/missing" {
+			want = " 404 19 "
+		}
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("%s: log %q does not contain %q", p, buf.String(), want)
+		}
+	}
+}
+
+func TestLogSampleRate(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	opts := LogOptions{
+		SampleRate:           3,
+		SlowRequestThreshold: 20 * time.Millisecond,
+		SlowRequestHook:      func(*CLFEntry, time.Duration) {},
+	}
+	h := LogWith(opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(opts.SlowRequestThreshold)
+		case "/missing":
+			http.NotFound(w, r)
+		case "/moved":
+			http.Redirect(w, r, "/", http.StatusFound)
+		case "/error":
+			http.Error(w, "error", http.StatusInternalServerError)
+		}
+	}))
+	// Successful requests which are not slow are numbered from one; the
+	// first, fourth and seventh are logged.
+	paths := []string{"/a", "/missing", "/b", "/slow", "/moved", "/error", "/c", "/d", "/e", "/f"}
+	want := []string{"/a", "/missing", "/slow", "/error", "/c", "/f"}
+	for _, p := range paths {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		_, req, _ := strings.Cut(line, "\"GET ")
+		p, _, _ := strings.Cut(req, "\"")
+		got = append(got, p)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+}
+
+func TestSlowRequestHook(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	type call struct {
+		path string
+		took time.Duration
+	}
+	var calls []call
+	opts := LogOptions{
+		SlowRequestThreshold: 20 * time.Millisecond,
+		SlowRequestHook: func(entry *CLFEntry, took time.Duration) {
+			calls = append(calls, call{entry.path, took})
+		},
+	}
+	h := LogWith(opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(opts.SlowRequestThreshold)
+		}
+	}))
+	for _, p := range []string{"/fast", "/slow", "/fast"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	if len(calls) != 1 || calls[0].path != "/slow" {
+		t.Fatalf("hook called for %v, want /slow only", calls)
+	}
+	if calls[0].took < opts.SlowRequestThreshold {
+		t.Errorf("hook reported %v, want at least %v", calls[0].took, opts.SlowRequestThreshold)
+	}
+	if strings.Contains(buf.String(), "slow request") {
+		t.Errorf("slow request logged as well as passed to the hook:\n%s", buf.String())
+	}
+}
+
+// entryRecorder is a LogBackend recording entries.
+type entryRecorder []*CLFEntry
+
+func (r *entryRecorder) Log(entry *CLFEntry) { *r = append(*r, entry) }
+
+func TestSetLogBackend(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+	var entries entryRecorder
+	SetLogBackend(&entries)
+	defer SetLogBackend(nil)
+
+	h := Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/page", nil))
+
+	if len(entries) != 1 {
+		t.Fatalf("backend received %d entries, want 1", len(entries))
+	}
+	if s := entries[0].String(); !strings.Contains(s, `"GET /page" HTTP/1.1 200 2 `) {
+		t.Errorf("entry %q does not describe the request", s)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("logger received %q, want nothing", buf.String())
+	}
+
+	SetLogBackend(nil)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/page", nil))
+	if len(entries) != 1 || !strings.Contains(buf.String(), `"GET /page"`) {
+		t.Errorf("with nil backend: backend has %d entries, logger %q", len(entries), buf.String())
+	}
+}
+
+func TestSetAccessLogger(t *testing.T) {
+	var access, errs bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&errs)
+	SetAccessLogger(NewLogger(&access))
+	defer SetAccessLogger(nil)
+
+	h := Apply(Log, Recover)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+	}))
+	for _, p := range []string{"/ok", "/panic"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+
+	if !strings.Contains(errs.String(), "panic: boom") {
+		t.Errorf("error log %q does not contain the panic", errs.String())
+	}
+	if strings.Contains(errs.String(), `"GET `) {
+		t.Errorf("error log %q contains access lines", errs.String())
+	}
+	if !strings.Contains(access.String(), `"GET /ok" HTTP/1.1 200 `) || !strings.Contains(access.String(), `"GET /panic" HTTP/1.1 500 `) {
+		t.Errorf("access log %q does not contain both requests", access.String())
+	}
+	if strings.Contains(access.String(), "boom") {
+		t.Errorf("access log %q contains the panic", access.String())
+	}
+}
+
+func TestNewHandlerLogs(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+	var entries entryRecorder
+	SetLogBackend(&entries)
+	defer SetLogBackend(nil)
+
+	h := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	r := httptest.NewRequest("GET", "https://bwsd.net/page", nil)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(entries) != 1 {
+		t.Fatalf("backend received %d entries, want 1", len(entries))
+	}
+	if s := entries[0].String(); !strings.Contains(s, `"GET /page" HTTP/1.1 200 2 `) {
+		t.Errorf("entry %q does not describe the request", s)
+	}
+}
+
+func BenchmarkLog(b *testing.B) {
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(io.Discard)
+
+	h := Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(w, r)
+	}
+}
+
+func TestCLFEntryString(t *testing.T) {
+	r := httptest.NewRequest("GET", "/index.html", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("User-Agent", "test-agent")
+	r.SetBasicAuth("alice", "secret")
+	uuid, err := ParseUUID("f47ac10b-58cc-4372-a567-0e02b2c3d479")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	e := NewCLFEntry(r, uuid)
+	e.ts = time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	e.status = http.StatusOK
+	e.size = 1234
+
+	want := `192.0.2.1 alice f47ac10b-58cc-4372-a567-0e02b2c3d479 [01/May/2024:12:30:00 +0000] "GET /index.html" HTTP/1.1 200 1234 "test-agent" "-"`
+	if got := e.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+	e.status = 0
+	if got := e.String(); !strings.Contains(got, ` HTTP/1.1 - 1234 `) {
+		t.Errorf("unknown status not logged as '-': %s", got)
+	}
+}
+
+func TestUUIDAppendTo(t *testing.T) {
+	uuid, _ := ParseUUID("f47ac10b-58cc-4372-a567-0e02b2c3d479")
+	if got := string(uuid.AppendTo([]byte("id="))); got != "id=f47ac10b-58cc-4372-a567-0e02b2c3d479" {
+		t.Errorf("got %q", got)
+	}
+	buf := make([]byte, 0, 36)
+	if n := testing.AllocsPerRun(100, func() { buf = uuid.AppendTo(buf[:0]) }); n != 0 {
+		t.Errorf("AppendTo allocates %v times, want 0", n)
+	}
+}
+
+func BenchmarkUUIDAppendTo(b *testing.B) {
+	var uuid UUID
+	buf := make([]byte, 0, 36)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = uuid.AppendTo(buf[:0])
+	}
+}
+
+func BenchmarkCLFEntry(b *testing.B) {
+	r := httptest.NewRequest("GET", "/index.html", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0")
+	e := NewCLFEntry(r, UUID{})
+	e.status, e.size = http.StatusOK, 1234
+
+	b.Run("Sprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fmt.Fprintln(io.Discard, fmt.Sprintf(CombinedLogFormat,
+				e.addr, e.userID, e.ident, e.ts.Format("02/Jan/2006:15:04:05 -0700"),
+				fmt.Sprintf("\"%s %s\"", e.method, e.path), e.proto, e.status, e.size, e.ua, e.referrer))
+		}
+	})
+	b.Run("String", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fmt.Fprintln(io.Discard, e.String())
+		}
+	})
+	b.Run("Write", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			e.Write(io.Discard)
+		}
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	h := func(sleep time.Duration) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(sleep):
+			case <-r.Context().Done():
+				return
+			}
+			io.WriteString(w, "done")
+		})
+	}
+
+	w := httptest.NewRecorder()
+	Timeout(time.Second)(h(0)).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("fast handler: got %d %q, want 200 done", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	TimeoutMessage(10*time.Millisecond, "too slow")(h(time.Minute)).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "too slow" {
+		t.Errorf("slow handler: got %d %q, want 503 %q", w.Code, w.Body.String(), "too slow")
+	}
+}
+
+func TestLogRequestBudget(t *testing.T) {
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(io.Discard)
+
+	const budget = 2 * time.Second
+	var ctx context.Context
+	var deadline time.Time
+	var ok bool
+	h := LogWith(LogOptions{RequestBudget: budget})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+		deadline, ok = ctx.Deadline()
+	}))
+
+	t0 := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !ok {
+		t.Fatalf("request context has no deadline")
+	}
+	if deadline.Before(t0.Add(budget)) || deadline.After(time.Now().Add(budget)) {
+		t.Errorf("deadline %v not within %v of the request", deadline.Sub(t0), budget)
+	}
+	if ctx.Err() != context.Canceled {
+		t.Errorf("request context not cancelled after the request: %v", ctx.Err())
+	}
+	if _, ok := ctx.Value("uuid").(UUID); !ok {
+		t.Errorf("request context has no UUID")
+	}
+}
+
+func TestFlushMiddleware(t *testing.T) {
+	h := Apply(FlushMiddleware(), Log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial")
+	}))
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(io.Discard)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !w.Flushed {
+		t.Errorf("response not flushed")
+	}
+
+	// Writers not supporting flushing are left alone.
+	var nf struct{ http.ResponseWriter }
+	nf.ResponseWriter = httptest.NewRecorder()
+	h.ServeHTTP(nf, httptest.NewRequest("GET", "/", nil))
+}
+
+func TestMaxConnections(t *testing.T) {
+	base := ActiveConnections()
+	release := make(chan struct{})
+	h := MaxConnections(2)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		}()
+	}
+	waitActive := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for ActiveConnections() != base+want {
+			if time.Now().After(deadline) {
+				t.Fatalf("ActiveConnections = %d, want %d", ActiveConnections()-base, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitActive(2)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("request over the limit: got %d, Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+	if n := ActiveConnections() - base; n != 2 {
+		t.Errorf("refused request counted: ActiveConnections = %d, want 2", n)
+	}
+
+	close(release)
+	wg.Wait()
+	waitActive(0)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("request after release: got %d, want 200", w.Code)
+	}
+}
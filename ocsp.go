@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspStapler attaches a cached OCSP response to certificates returned by an
+// underlying GetCertificate function, such as that of an autocert.Manager.
+// Responses are fetched and refreshed in the background so that handshakes
+// never block on the OCSP responder.
+type ocspStapler struct {
+	get    func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	client *http.Client
+
+	mu      sync.Mutex
+	staples map[string]*ocspStaple // keyed by leaf certificate DER
+}
+
+type ocspStaple struct {
+	raw      []byte    // DER-encoded OCSP response
+	pending  bool      // fetch in progress
+	skip     bool      // certificate names no OCSP server
+	refresh  time.Time // time after which the response should be refetched
+	notAfter time.Time // response expiry
+	used     time.Time // time the certificate was last served
+}
+
+// ocspIdle is how long a certificate may go unserved before its staple is
+// discarded, as happens once a renewed certificate replaces it.
+const ocspIdle = 24 * time.Hour
+
+var errNoOCSPServer = errors.New("no OCSP server")
+
+func newOCSPStapler(get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *ocspStapler {
+	return &ocspStapler{
+		get:     get,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		staples: make(map[string]*ocspStaple),
+	}
+}
+
+// GetCertificate returns the underlying certificate with its OCSP response
+// stapled, if one is available.
+func (s *ocspStapler) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := s.get(hello)
+	if err != nil || cert == nil || len(cert.Certificate) < 2 {
+		return cert, err
+	}
+
+	key := string(cert.Certificate[0])
+	now := time.Now()
+
+	s.mu.Lock()
+	st, ok := s.staples[key]
+	if !ok {
+		st = &ocspStaple{}
+		s.staples[key] = st
+	}
+	st.used = now
+	if !st.skip && !st.pending && !now.Before(st.refresh) {
+		st.pending = true
+		go s.fetch(key, cert)
+	}
+	raw := st.raw
+	if now.After(st.notAfter) {
+		raw = nil
+	}
+	s.mu.Unlock()
+
+	if raw == nil {
+		return cert, nil
+	}
+	c := *cert
+	c.OCSPStaple = raw
+	return &c, nil
+}
+
+// fetch requests a new OCSP response for cert and stores it under key,
+// discarding the staples of certificates no longer served.
+func (s *ocspStapler) fetch(key string, cert *tls.Certificate) {
+	raw, resp, err := s.request(cert)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, st := range s.staples {
+		if k != key && !st.pending && now.Sub(st.used) > ocspIdle {
+			delete(s.staples, k)
+		}
+	}
+	st := s.staples[key]
+	st.pending = false
+	if errors.Is(err, errNoOCSPServer) {
+		logger.Printf("ocsp: %v; not stapling", err)
+		st.skip = true
+		return
+	}
+	if err != nil {
+		logger.Printf("ocsp: %v", err)
+		st.refresh = time.Now().Add(time.Minute)
+		return
+	}
+	st.raw = raw
+	st.notAfter = resp.NextUpdate
+	// Refresh halfway through the validity period.
+	st.refresh = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+}
+
+func (s *ocspStapler) request(cert *tls.Certificate) ([]byte, *ocsp.Response, error) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("%w for %v", errNoOCSPServer, leaf.Subject)
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := s.client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%s: %s", leaf.OCSPServer[0], r.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.Status != ocsp.Good {
+		return nil, nil, fmt.Errorf("OCSP status of %v is %s", leaf.Subject, ocspStatus(resp.Status))
+	}
+	if resp.NextUpdate.IsZero() {
+		return nil, nil, fmt.Errorf("OCSP response for %v has no next update", leaf.Subject)
+	}
+	return raw, resp, nil
+}
+
+// ocspStatus returns the name of an OCSP certificate status.
+func ocspStatus(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	case ocsp.Unknown:
+		return "unknown"
+	}
+	return fmt.Sprintf("%d", status)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// newOCSPTestCert returns a certificate chain whose leaf names a responder
+// answering with status, or no responder if status is negative, with the
+// leaf and CA certificates and a count of the requests to the responder.
+func newOCSPTestCert(t *testing.T, status int) (*tls.Certificate, *x509.Certificate, *x509.Certificate, *atomic.Int32) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	var hits atomic.Int32
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		b, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	t.Cleanup(responder.Close)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if status >= 0 {
+		leafTmpl.OCSPServer = []string{responder.URL}
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, ca, leafKey.Public(), caKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leafDER, caDER},
+		PrivateKey:  leafKey,
+	}
+	return cert, leaf, ca, &hits
+}
+
+// waitFetched waits until no OCSP fetch is in progress for cert.
+func waitFetched(t *testing.T, s *ocspStapler, cert *tls.Certificate) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		st := s.staples[string(cert.Certificate[0])]
+		done := st != nil && !st.pending
+		s.mu.Unlock()
+		if done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("OCSP fetch did not complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOCSPStaple(t *testing.T) {
+	cert, leaf, ca, _ := newOCSPTestCert(t, ocsp.Good)
+	s := newOCSPStapler(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return cert, nil
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c, err := s.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if c.OCSPStaple != nil {
+			resp, err := ocsp.ParseResponseForCert(c.OCSPStaple, leaf, ca)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if resp.Status != ocsp.Good {
+				t.Errorf("staple status = %v, want good", resp.Status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("OCSP staple not populated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if cert.OCSPStaple != nil {
+		t.Errorf("underlying certificate modified")
+	}
+}
+
+func TestOCSPStapleNotGood(t *testing.T) {
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(io.Discard)
+
+	for _, status := range []int{ocsp.Revoked, ocsp.Unknown} {
+		cert, _, _, hits := newOCSPTestCert(t, status)
+		s := newOCSPStapler(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert, nil
+		})
+		s.GetCertificate(&tls.ClientHelloInfo{})
+		waitFetched(t, s, cert)
+		if hits.Load() == 0 {
+			t.Fatalf("%s: responder not queried", ocspStatus(status))
+		}
+		c, err := s.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if c.OCSPStaple != nil {
+			t.Errorf("%s response stapled", ocspStatus(status))
+		}
+	}
+}
+
+func TestOCSPNoServer(t *testing.T) {
+	defer func(l *log.Logger) { logger = l }(logger)
+	var buf bytes.Buffer
+	logger = NewLogger(&buf)
+
+	cert, _, _, _ := newOCSPTestCert(t, -1)
+	s := newOCSPStapler(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return cert, nil
+	})
+	s.GetCertificate(&tls.ClientHelloInfo{})
+	waitFetched(t, s, cert)
+
+	// Make the certificate due for refresh, as it would be a minute later.
+	s.mu.Lock()
+	s.staples[string(cert.Certificate[0])].refresh = time.Time{}
+	s.mu.Unlock()
+	for i := 0; i < 3; i++ {
+		if c, _ := s.GetCertificate(&tls.ClientHelloInfo{}); c.OCSPStaple != nil {
+			t.Errorf("staple without OCSP server")
+		}
+		waitFetched(t, s, cert)
+	}
+	if n := strings.Count(buf.String(), "no OCSP server"); n != 1 {
+		t.Errorf("logged %d times, want once:\n%s", n, buf.String())
+	}
+}
+
+func TestOCSPPrune(t *testing.T) {
+	cert, _, _, _ := newOCSPTestCert(t, ocsp.Good)
+	s := newOCSPStapler(func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return cert, nil
+	})
+	s.staples["old"] = &ocspStaple{raw: []byte("old"), used: time.Now().Add(-2 * ocspIdle)}
+	s.staples["recent"] = &ocspStaple{raw: []byte("recent"), used: time.Now().Add(-time.Minute)}
+
+	s.GetCertificate(&tls.ClientHelloInfo{})
+	waitFetched(t, s, cert)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.staples["old"]; ok {
+		t.Errorf("staple of unserved certificate kept")
+	}
+	if _, ok := s.staples["recent"]; !ok {
+		t.Errorf("staple of recently served certificate discarded")
+	}
+	if _, ok := s.staples[string(cert.Certificate[0])]; !ok {
+		t.Errorf("staple of current certificate discarded")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// pprofHandler returns a handler serving the net/http/pprof endpoints beneath
+// /debug/pprof/.
+func pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// listenPprof listens on addr, which must be a loopback address, e.g.
+// "127.0.0.1:6060", or a Unix domain socket of the form "unix:/run/pprof.sock",
+// so that profiles are not exposed publicly by mistake.
+func listenPprof(addr string) (net.Listener, error) {
+	if path, ok := UnixSocketPath(addr); ok {
+		return listenUnix(path, UnixOptions{Mode: 0600})
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("pprof: %v", err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("pprof: %s is not a loopback address or Unix socket", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// ServePprof serves the net/http/pprof endpoints on addr, a loopback address
+// or Unix domain socket. The server is internal: it is not wrapped in the
+// site's middleware, and has no write timeout, so that CPU profiles and
+// traces may run for their requested duration.
+func ServePprof(addr string) error {
+	l, err := listenPprof(addr)
+	if err != nil {
+		return err
+	}
+	s := &http.Server{
+		ReadTimeout:    5 * time.Second,
+		IdleTimeout:    60 * time.Second,
+		Handler:        pprofHandler(),
+		ErrorLog:       logger,
+		MaxHeaderBytes: MaxHeaderBytes,
+	}
+	log.Printf("pprof: listen: %s", addr)
+	return s.Serve(l)
+}
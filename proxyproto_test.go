@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProxyListenerLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer func(l *log.Logger) { logger = l }(logger)
+	logger = NewLogger(&buf)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s := &http.Server{Handler: Log(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))}
+	go s.Serve(ProxyListener(l))
+	defer s.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\r\n")
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	resp.Body.Close()
+	s.Close()
+
+	if got := buf.String(); !strings.HasPrefix(got, "203.0.113.7 ") {
+		t.Errorf("log entry %q does not begin with the client address", got)
+	}
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(cmd, fam byte, addrs []byte) string {
+		var b bytes.Buffer
+		b.Write(proxyV2Sig)
+		b.WriteByte(0x20 | cmd)
+		b.WriteByte(fam)
+		binary.Write(&b, binary.BigEndian, uint16(len(addrs)))
+		b.Write(addrs)
+		return b.String()
+	}
+	inet := []byte{203, 0, 113, 7, 192, 0, 2, 1, 0xdc, 0x04, 0x01, 0xbb}
+
+	tests := []struct {
+		hdr  string
+		want string // client address, "" for none, "error" for an error
+	}{
+		{"PROXY TCP4 203.0.113.7 192.0.2.1 56324 443\r\n", "203.0.113.7:56324"},
+		{"PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{"GET / HTTP/1.1\r\n", "error"},
+		{"PROXY TCP4 203.0.113.7\r\n", "error"},
+		{v2(1, 0x11, inet), "203.0.113.7:56324"},
+		{v2(0, 0x00, nil), ""},
+		{v2(1, 0x11, inet[:4]), "error"},
+	}
+	for _, tt := range tests {
+		addr, err := readProxyHeader(bufio.NewReader(strings.NewReader(tt.hdr)))
+		got := ""
+		switch {
+		case err != nil:
+			got = "error"
+		case addr != nil:
+			got = addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("header %q: got %q (%v), want %q", tt.hdr, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RedirectRule redirects requests whose path matches From to To.
+type RedirectRule struct {
+	// From is an exact path, or a pattern in which each '*' matches any
+	// sequence of characters, including '/'.
+	From string
+
+	// To is the destination path or URL. Text matched by the n'th '*' in
+	// From is substituted for $n or ${n}, the latter being needed where a
+	// digit follows; ":splat" is an alias for $1. Any other '$' is literal.
+	To string
+
+	// Code is the redirect status: 301, 302, 307 or 308. Zero selects 301.
+	Code int
+}
+
+type redirect struct {
+	re   *regexp.Regexp
+	to   string
+	code int
+}
+
+func compileRedirect(r RedirectRule) (redirect, error) {
+	code := r.Code
+	switch code {
+	case 0:
+		code = http.StatusMovedPermanently
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return redirect{}, fmt.Errorf("redirect %s: invalid status code %d", r.From, r.Code)
+	}
+
+	parts := strings.Split(r.From, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, "(.*)") + "$")
+	if err != nil {
+		return redirect{}, fmt.Errorf("redirect %s: %v", r.From, err)
+	}
+	to := redirectTemplate(strings.ReplaceAll(r.To, ":splat", "${1}"))
+	return redirect{re: re, to: to, code: code}, nil
+}
+
+// redirectTemplate returns to as a template for regexp.Expand, with each $n
+// braced, so that "$1_x" is not read as the group named "1_x", and every other
+// '$' escaped.
+func redirectTemplate(to string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(to, '$')
+		if i < 0 {
+			b.WriteString(to)
+			return b.String()
+		}
+		b.WriteString(to[:i])
+		to = to[i+1:]
+		n := 0
+		for n < len(to) && '0' <= to[n] && to[n] <= '9' {
+			n++
+		}
+		end := strings.IndexByte(to, '}')
+		switch {
+		case n > 0:
+			b.WriteString("${" + to[:n] + "}")
+			to = to[n:]
+		case strings.HasPrefix(to, "{") && end > 1 && strings.Trim(to[1:end], "0123456789") == "":
+			b.WriteString("$" + to[:end+1])
+			to = to[end+1:]
+		default:
+			b.WriteString("$$")
+		}
+	}
+}
+
+// Redirects returns a middleware redirecting requests matching one of rules.
+// Rules are evaluated in order and the first match wins. The query of the
+// request is carried over to the destination. Redirects returns an error if
+// a rule is invalid.
+func Redirects(rules []RedirectRule) (Middleware, error) {
+	rs := make([]redirect, len(rules))
+	for i, r := range rules {
+		c, err := compileRedirect(r)
+		if err != nil {
+			return nil, err
+		}
+		rs[i] = c
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, rd := range rs {
+				if m := rd.re.FindStringSubmatchIndex(r.URL.Path); m != nil {
+					to := string(rd.re.ExpandString(nil, rd.to, r.URL.Path, m))
+					if q := r.URL.RawQuery; q != "" {
+						if strings.Contains(to, "?") {
+							to += "&" + q
+						} else {
+							to += "?" + q
+						}
+					}
+					http.Redirect(w, r, to, rd.code)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// LoadRedirectsFile parses redirect rules from a Netlify-style _redirects
+// file, with one "from to [code]" rule per line. Blank lines and lines
+// beginning with '#' are ignored.
+func LoadRedirectsFile(name string) ([]RedirectRule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []RedirectRule
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected \"from to [code]\"", name, n)
+		}
+		r := RedirectRule{From: fields[0], To: fields[1]}
+		if len(fields) == 3 {
+			// A trailing '!' forces the redirect even if the path exists;
+			// redirects always take precedence here.
+			code, err := strconv.Atoi(strings.TrimSuffix(fields[2], "!"))
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid status code %q", name, n, fields[2])
+			}
+			r.Code = code
+		}
+		if _, err := compileRedirect(r); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, n, err)
+		}
+		rules = append(rules, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
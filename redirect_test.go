@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRedirects(t *testing.T) {
+	mw, err := Redirects([]RedirectRule{
+		{From: "/old.html", To: "/new.html"},
+		{From: "/blog/*", To: "/posts/:splat", Code: http.StatusFound},
+		{From: "/img/*.png", To: "/images/$1_x2.png"},
+		{From: "/v/*/*", To: "/version${1}0/$2abc"},
+		{From: "/search", To: "/find?src=old"},
+		{From: "/price", To: "/cost$x"},
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	h := mw(http.NotFoundHandler())
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/old.html", http.StatusMovedPermanently, "/new.html"},
+		{"/blog/2023/hello", http.StatusFound, "/posts/2023/hello"},
+		{"/about.html", http.StatusNotFound, ""},
+		{"/img/logo.png", http.StatusMovedPermanently, "/images/logo_x2.png"},
+		{"/v/1/doc", http.StatusMovedPermanently, "/version10/docabc"},
+		{"/old.html?a=1&b=2", http.StatusMovedPermanently, "/new.html?a=1&b=2"},
+		{"/search?q=go", http.StatusMovedPermanently, "/find?src=old&q=go"},
+		{"/price", http.StatusMovedPermanently, "/cost$x"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Location"); got != tt.location {
+			t.Errorf("%s: got Location %q, want %q", tt.path, got, tt.location)
+		}
+	}
+}
+
+func TestRedirectsInvalid(t *testing.T) {
+	if _, err := Redirects([]RedirectRule{{From: "/old", To: "/new", Code: http.StatusOK}}); err == nil {
+		t.Errorf("expected error for status %d", http.StatusOK)
+	}
+}
+
+func TestLoadRedirectsFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "_redirects")
+	data := "# comment\n\n/old /new\n/docs/* /manual/:splat 302!\n"
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	rules, err := LoadRedirectsFile(name)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	want := []RedirectRule{
+		{From: "/old", To: "/new"},
+		{From: "/docs/*", To: "/manual/:splat", Code: 302},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d = %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+
+	if err := os.WriteFile(name, []byte("/old /new 200\n"), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if _, err := LoadRedirectsFile(name); err == nil {
+		t.Errorf("expected error for invalid status code")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RobotsOptions are the rules of a generated robots.txt (RFC 9309), applying
+// to all user agents. With no rules, all paths may be crawled.
+type RobotsOptions struct {
+	Allow    []string // Paths which may be crawled
+	Disallow []string // Paths which may not be crawled
+	Sitemap  string   // Absolute sitemap URL, if any
+}
+
+// String returns the robots.txt content for o.
+func (o RobotsOptions) String() string {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, p := range o.Allow {
+		fmt.Fprintf(&b, "Allow: %s\n", p)
+	}
+	for _, p := range o.Disallow {
+		fmt.Fprintf(&b, "Disallow: %s\n", p)
+	}
+	if len(o.Allow) == 0 && len(o.Disallow) == 0 {
+		b.WriteString("Disallow:\n")
+	}
+	if o.Sitemap != "" {
+		fmt.Fprintf(&b, "\nSitemap: %s\n", o.Sitemap)
+	}
+	return b.String()
+}
+
+// Robots returns a middleware serving a robots.txt generated from opts when
+// root has none. A robots.txt on disk always takes precedence.
+func Robots(root http.FileSystem, opts RobotsOptions) Middleware {
+	body := opts.String()
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/robots.txt" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if f, err := root.Open("/robots.txt"); err == nil {
+				f.Close()
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if r.Method != "HEAD" {
+				fmt.Fprint(w, body)
+			}
+		})
+	}
+}
+
+// noIndexRobots disallows crawling of all paths.
+const noIndexRobots = "User-agent: *\nDisallow: /\n"
+
+// NoIndex returns a middleware keeping crawlers out of a site, e.g. a staging
+// instance: every response has an "X-Robots-Tag: noindex, nofollow" header,
+// and robots.txt disallows all paths, in place of any robots.txt on disk.
+func NoIndex() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+			if r.URL.Path != "/robots.txt" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			if r.Method != "HEAD" {
+				fmt.Fprint(w, noIndexRobots)
+			}
+		})
+	}
+}
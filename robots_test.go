@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRobots(t *testing.T) {
+	opts := RobotsOptions{
+		Disallow: []string{"/private/"},
+		Sitemap:  "https://example.com/sitemap.xml",
+	}
+	want := "User-agent: *\nDisallow: /private/\n\nSitemap: https://example.com/sitemap.xml\n"
+
+	dir := t.TempDir()
+	root := http.Dir(dir)
+	h := Robots(root, opts)(http.FileServer(root))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/robots.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("generated: got %d %q, want %d %q", w.Code, w.Body.String(), http.StatusOK, want)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("generated: got Content-Type %q", got)
+	}
+
+	onDisk := "User-agent: *\nDisallow: /\n"
+	if err := os.WriteFile(filepath.Join(dir, "robots.txt"), []byte(onDisk), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/robots.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != onDisk {
+		t.Errorf("on disk: got %d %q, want %d %q", w.Code, w.Body.String(), http.StatusOK, onDisk)
+	}
+}
+
+func TestRobotsOptionsDefault(t *testing.T) {
+	if got, want := (RobotsOptions{}).String(), "User-agent: *\nDisallow:\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNoIndex(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"robots.txt": "User-agent: *\nDisallow:\n",
+		"index.html": "<h1>hello</h1>",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+	fsrv := http.FileServer(http.Dir(dir))
+
+	for _, enabled := range []bool{true, false} {
+		h := http.Handler(fsrv)
+		if enabled {
+			h = NoIndex()(h)
+		}
+		for _, path := range []string{"/", "/robots.txt"} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			tag := w.Header().Get("X-Robots-Tag")
+			if enabled && tag != "noindex, nofollow" || !enabled && tag != "" {
+				t.Errorf("enabled %v, %s: got X-Robots-Tag %q", enabled, path, tag)
+			}
+			if path == "/robots.txt" && enabled != (w.Body.String() == noIndexRobots) {
+				t.Errorf("enabled %v: got robots.txt %q", enabled, w.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SecurityTXTOptions are the fields of a generated security.txt (RFC 9116).
+type SecurityTXTOptions struct {
+	Contact         []string  // Contact URIs, e.g. "mailto:security@example.com"
+	Expires         time.Time // Time after which the file is stale
+	Encryption      string    // URI of a key for encrypted communication
+	Policy          string    // URI of the vulnerability disclosure policy
+	Canonical       []string  // URIs at which the file is published
+	Acknowledgments string    // URI of a page recognizing researchers
+}
+
+// String returns the security.txt content for o.
+func (o SecurityTXTOptions) String() string {
+	var b strings.Builder
+	for _, c := range o.Contact {
+		fmt.Fprintf(&b, "Contact: %s\n", c)
+	}
+	// RFC 9116, 2.5.5 requires the Internet date/time format of RFC 3339.
+	if !o.Expires.IsZero() {
+		fmt.Fprintf(&b, "Expires: %s\n", o.Expires.UTC().Format(time.RFC3339))
+	}
+	if o.Encryption != "" {
+		fmt.Fprintf(&b, "Encryption: %s\n", o.Encryption)
+	}
+	if o.Policy != "" {
+		fmt.Fprintf(&b, "Policy: %s\n", o.Policy)
+	}
+	for _, c := range o.Canonical {
+		fmt.Fprintf(&b, "Canonical: %s\n", c)
+	}
+	if o.Acknowledgments != "" {
+		fmt.Fprintf(&b, "Acknowledgments: %s\n", o.Acknowledgments)
+	}
+	return b.String()
+}
+
+// Validate reports whether o has the fields required by RFC 9116, section
+// 2.5: at least one Contact, and an Expires time which has not passed.
+func (o SecurityTXTOptions) Validate() error {
+	if len(o.Contact) == 0 {
+		return errors.New("security.txt: no Contact field")
+	}
+	if o.Expires.IsZero() {
+		return errors.New("security.txt: no Expires field")
+	}
+	if !o.Expires.After(time.Now()) {
+		return fmt.Errorf("security.txt: expired %s", o.Expires.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SecurityTXT returns a handler serving a security.txt generated from opts,
+// or an error if opts is not valid.
+func SecurityTXT(opts SecurityTXTOptions) (http.HandlerFunc, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	body := opts.String()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "max-age=86400")
+		if r.Method != "HEAD" {
+			fmt.Fprint(w, body)
+		}
+	}, nil
+}
+
+// securityTXTPath is the location of security.txt (RFC 9116, section 3).
+const securityTXTPath = WellKnownPrefix + "security.txt"
+
+// securityTXTHandler returns a handler serving security.txt from root or, if
+// root has none, generated from opts. If opts is nil too, it responds 404.
+func securityTXTHandler(root http.FileSystem, opts *SecurityTXTOptions) (http.Handler, error) {
+	var generated http.HandlerFunc
+	if opts != nil {
+		var err error
+		if generated, err = SecurityTXT(*opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f, err := root.Open(securityTXTPath); err == nil {
+			defer f.Close()
+			if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+				return
+			}
+		}
+		if generated == nil {
+			http.NotFound(w, r)
+			return
+		}
+		generated(w, r)
+	}), nil
+}
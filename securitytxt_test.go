@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSecurityTXT(t *testing.T) {
+	dir := t.TempDir()
+	wk := NewWellKnownMux()
+	h, err := securityTXTHandler(http.Dir(dir), &SecurityTXTOptions{
+		Contact:         []string{"mailto:security@example.com"},
+		Expires:         time.Date(2099, 1, 2, 3, 4, 5, 0, time.UTC),
+		Encryption:      "https://example.com/pgp-key.txt",
+		Policy:          "https://example.com/security-policy",
+		Canonical:       []string{"https://example.com/.well-known/security.txt"},
+		Acknowledgments: "https://example.com/hall-of-fame",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	wk.Register("security.txt", h)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, httptest.NewRequest("GET", "/.well-known/security.txt", nil))
+		if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("got Content-Type %q, want text/plain; charset=utf-8", got)
+		}
+		return w
+	}
+
+	want := "Contact: mailto:security@example.com\n" +
+		"Expires: 2099-01-02T03:04:05Z\n" +
+		"Encryption: https://example.com/pgp-key.txt\n" +
+		"Policy: https://example.com/security-policy\n" +
+		"Canonical: https://example.com/.well-known/security.txt\n" +
+		"Acknowledgments: https://example.com/hall-of-fame\n"
+	w := get()
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("generated: got %d %q, want %d %q", w.Code, w.Body.String(), http.StatusOK, want)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "max-age=86400" {
+		t.Errorf("generated: got Cache-Control %q, want max-age=86400", got)
+	}
+
+	onDisk := "Contact: https://example.com/report\n"
+	if err := os.MkdirAll(filepath.Join(dir, ".well-known"), 0755); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".well-known", "security.txt"), []byte(onDisk), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if w := get(); w.Code != http.StatusOK || w.Body.String() != onDisk {
+		t.Errorf("on disk: got %d %q, want %d %q", w.Code, w.Body.String(), http.StatusOK, onDisk)
+	}
+}
+
+func TestSecurityTXTInvalid(t *testing.T) {
+	contact := []string{"mailto:security@example.com"}
+	tests := []struct {
+		name string
+		opts SecurityTXTOptions
+	}{
+		{"no contact", SecurityTXTOptions{Expires: time.Now().Add(time.Hour)}},
+		{"no expires", SecurityTXTOptions{Contact: contact}},
+		{"expired", SecurityTXTOptions{Contact: contact, Expires: time.Now().Add(-time.Hour)}},
+	}
+	for _, tt := range tests {
+		if _, err := SecurityTXT(tt.opts); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+		if _, err := securityTXTHandler(http.Dir(t.TempDir()), &tt.opts); err == nil {
+			t.Errorf("%s: expected error from securityTXTHandler", tt.name)
+		}
+	}
+
+	if _, err := securityTXTHandler(http.Dir(t.TempDir()), nil); err != nil {
+		t.Errorf("no options: %v", err)
+	}
+}
@@ -1,69 +1,623 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
+
+	"golang.org/x/crypto/acme/autocert"
 )
 
-func ListenAndServe(mux *http.ServeMux, addr, dirCache string, selfSign bool) error {
-	var err error
-	var cfg *tls.Config
-	errc := make(chan error, 3)
+// Config holds the server settings, typically populated from command-line
+// flags.
+type Config struct {
+	Addr     string // Listen address
+	FSDir    string // File system directory
+	DirCache string // X509 certificate cache
+	SelfSign bool   // Self-sign X509 certificate
+	CertFile string // PEM-encoded X509 certificate
+	KeyFile  string // PEM-encoded X509 private key
+	KeyType  string // Self-signed certificate key algorithm
 
-	if !selfSign {
-		m, err := autocertX509(dirCache)
-		if err != nil {
-			log.Fatal(err)
+	// TLSMinVersion is the minimum TLS version accepted. Zero selects
+	// TLS 1.3.
+	TLSMinVersion uint16
+
+	// Timeouts are the server timeouts. Zero values select the
+	// corresponding DefaultServerTimeouts.
+	Timeouts ServerTimeouts
+
+	// ClientCAFile, if set, is a PEM-encoded CA bundle used to require and
+	// verify client certificates.
+	ClientCAFile string
+
+	// HTTPAddr is the listen address for HTTPS redirects and ACME HTTP-01
+	// challenges, typically ":80". If empty, no plain HTTP listener is
+	// started and ACME certificates are obtained using TLS-ALPN-01.
+	HTTPAddr string
+
+	// PprofAddr, if set, is the loopback address or "unix:" socket path on
+	// which the net/http/pprof endpoints are served.
+	PprofAddr string
+
+	// HealthPath and ReadyPath are the paths of the liveness and
+	// readiness endpoints. If empty, DefaultHealthPath and DefaultReadyPath
+	// are used.
+	HealthPath string
+	ReadyPath  string
+
+	// ACMEDirectory is the ACME CA directory URL. If empty, Let's Encrypt
+	// is used.
+	ACMEDirectory string
+
+	// UnixSocket, if set, is the path of a Unix domain socket served in place
+	// of Addr. An Addr of the form "unix:path" is equivalent.
+	UnixSocket string
+	Unix       UnixOptions
+
+	// CSP holds the Content-Security-Policy directives and AllowedMethods
+	// the request methods accepted. If nil, the defaults are used.
+	CSP            map[string][]string
+	AllowedMethods []string
+
+	// Middleware is the chain of middlewares applied to requests, in order.
+	// If empty, DefaultMiddleware is used.
+	Middleware []Middleware
+
+	// ProxyProtocol reads a PROXY protocol header from each TCP connection
+	// to obtain the client address, for use behind a layer 4 load balancer.
+	ProxyProtocol bool
+
+	// HTTP3 serves HTTP/3 over QUIC on the UDP port of Addr, in addition
+	// to HTTP/2, and advertises it with an Alt-Svc header.
+	HTTP3 bool
+
+	// CanonicalHost, if set, is the preferred form of the site's host:
+	// requests for its "www." or apex counterpart are redirected to it.
+	CanonicalHost string
+
+	// Redirects are applied to requests before the file system is served.
+	Redirects []RedirectRule
+
+	// Robots are the rules of the robots.txt served when FSDir has none.
+	Robots RobotsOptions
+
+	// ContentTypes overrides the content types of files by extension. If
+	// nil, DefaultContentTypes is used.
+	ContentTypes map[string]string
+
+	// Mounts are additional directories served beneath URL path prefixes.
+	Mounts []Mount
+
+	// Embedded, if set, is the file system served when FSDir is empty or
+	// not an existing directory, e.g. a site embedded in the binary. It is
+	// served by RangeHandler, so directories without an index.html are not
+	// listed.
+	Embedded fs.FS
+
+	// Favicon is the image served for /favicon.ico. If empty, favicon.ico
+	// in FSDir is used.
+	Favicon string
+
+	// SecurityTXT, if set, generates /.well-known/security.txt when FSDir
+	// has none.
+	SecurityTXT *SecurityTXTOptions
+
+	// VirtualHosts maps request hosts to the handlers serving them, in
+	// place of FSDir; a nil handler serves FSDir. FSDir is served to other
+	// hosts unless a "*" route is given. ACME certificates are issued for
+	// the hosts routed, other than "*".
+	VirtualHosts map[string]http.Handler
+
+	// WellKnown maps well-known URI suffixes, e.g. "security.txt", to their
+	// handlers. Other requests beneath /.well-known/ are not served from the
+	// file system.
+	WellKnown map[string]http.Handler
+
+	// StripTrailingSlash redirects requests for files with a trailing
+	// slash to the path without it. Directories are always redirected to
+	// the path with a trailing slash.
+	StripTrailingSlash bool
+
+	// NoIndex keeps crawlers out, e.g. of staging instances: responses are
+	// marked noindex and robots.txt disallows all paths.
+	NoIndex bool
+
+	// Watch reloads pages open in browsers when files in FSDir change,
+	// for development. It is ignored unless SelfSign is set.
+	Watch bool
+
+	// ServerHeader is the Server header of responses. If empty, any Server
+	// header set by a handler is removed.
+	ServerHeader string
+
+	// Maintenance, if its File is set, serves 503 Service Unavailable to
+	// all but health checks and ACME challenges while the file exists.
+	Maintenance MaintenanceOptions
+
+	// DNSProvider, if set, answers ACME DNS-01 challenges in place of
+	// HTTP-01, e.g. for wildcard certificates.
+	DNSProvider DNSProvider
+
+	// Metrics enables the metrics endpoint at DefaultMetricsPath,
+	// accessible from client addresses in the MetricsAllow CIDR ranges.
+	Metrics      bool
+	MetricsAllow []string
+
+	certs *CertProvider     // reloadable certificate, if any
+	acme  *autocert.Manager // ACME manager answering HTTP-01 challenges, if any
+}
+
+// ReloadConfig applies the reloadable settings of c to the running server:
+// the certificate is read from disk (or regenerated if self-signed), and the
+// CSP and allowed methods are replaced. Established connections are not
+// affected.
+func ReloadConfig(c *Config) error {
+	if c.certs != nil {
+		if err := c.certs.Reload(); err != nil {
+			return fmt.Errorf("certificate reload: %v", err)
+		}
+	}
+	setHeaderPolicy(c.CSP, c.AllowedMethods)
+	return nil
+}
+
+func ListenAndServe(mux *http.ServeMux, c *Config) error {
+	errc := make(chan error, 1)
+
+	cfg, err := NewX509Certificate(c)
+	if err != nil {
+		return err
+	}
+	if c.certs != nil {
+		defer c.certs.Close()
+	}
+	if c.ClientCAFile != "" {
+		if err := RequireClientCerts(cfg, c.ClientCAFile); err != nil {
+			return err
 		}
-		cfg = m.TLSConfig()
+	}
+	if c.acme != nil && c.HTTPAddr != "" {
+		// A failure here, e.g. when the port is already in use, is not
+		// fatal: autocert falls back to TLS-ALPN-01 challenges.
 		go func() {
-			errc <- http.ListenAndServe(":80", m.HTTPHandler(nil))
+			err := newChallengeServer(c.HTTPAddr, c.acme).ListenAndServe()
+			logger.Printf("challenge listener %s: %v", c.HTTPAddr, err)
 		}()
-	} else {
-		if cfg, err = selfSignedX509(dirCache); err != nil {
-			log.Fatal(err)
-		}
 	}
 
-	cfg.MinVersion = tls.VersionTLS13
-	s := &http.Server{
+	if c.certs != nil && c.HTTPAddr != "" {
+		go func() {
+			err := newRedirectServer(c.HTTPAddr, c.Addr).ListenAndServe()
+			logger.Printf("redirect listener %s: %v", c.HTTPAddr, err)
+		}()
+	}
+
+	setHeaderPolicy(c.CSP, c.AllowedMethods)
+	defer reloadOnHangup(c)()
+
+	s := newServer(mux, c, cfg)
+	defer s.Close()
+	if c.HTTP3 {
+		h3 := newHTTP3Server(c.Addr, s.Handler, cfg)
+		defer h3.Close()
+		s.Handler = AltSvc(c.Addr)(s.Handler)
+		go func() {
+			err := h3.ListenAndServe()
+			logger.Printf("http3 listener %s: %v", c.Addr, err)
+		}()
+	}
+	done := shutdownOnSignal(s)
+	addr := c.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("listen: %s", c.Addr)
+	if c.ProxyProtocol {
+		l = ProxyListener(l)
+	}
+	go func() { errc <- s.ServeTLS(connMetrics.Listener(l), "", "") }()
+
+	if err := <-errc; err != http.ErrServerClosed {
+		return err
+	}
+	return shutdownError(<-done)
+}
+
+// shutdownTimeout is the time allowed for in-flight requests to complete on
+// shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// ErrShutdownForced is returned by ListenAndServe and ListenAndServeUnix when
+// requests were still in flight at the shutdown deadline, and their
+// connections were closed.
+var ErrShutdownForced = errors.New("shutdown: connections closed at deadline")
+
+// ShutdownResult reports how a graceful shutdown completed.
+type ShutdownResult int
+
+const (
+	ShutdownClean  ShutdownResult = iota // All requests completed
+	ShutdownForced                       // Connections closed at the deadline
+)
+
+func (r ShutdownResult) String() string {
+	if r == ShutdownForced {
+		return "forced"
+	}
+	return "clean"
+}
+
+// GracefulShutdown shuts down s, allowing in-flight requests timeout to
+// complete. Connections still active at the deadline are closed, and their
+// number logged.
+func GracefulShutdown(s *http.Server, timeout time.Duration) ShutdownResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("shutdown: %d connections still active after %v; closing", connMetrics.Summary().Active, timeout)
+		s.Close()
+		return ShutdownForced
+	}
+	if err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	return ShutdownClean
+}
+
+// shutdownOnSignal gracefully shuts down s on SIGINT or SIGTERM, marking the
+// server as draining. The result is sent on the returned channel once
+// shutdown completes.
+func shutdownOnSignal(s *http.Server) <-chan ShutdownResult {
+	done := make(chan ShutdownResult, 1)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-ch
+		log.Printf("signal %v received; shutting down", sig)
+		health.Drain()
+		done <- GracefulShutdown(s, shutdownTimeout)
+	}()
+	return done
+}
+
+// shutdownError returns ErrShutdownForced if r is ShutdownForced.
+func shutdownError(r ShutdownResult) error {
+	if r == ShutdownForced {
+		return ErrShutdownForced
+	}
+	return nil
+}
+
+// newChallengeServer returns a plain HTTP server on addr answering ACME
+// HTTP-01 challenges for m and redirecting all other requests to HTTPS.
+func newChallengeServer(addr string, m *autocert.Manager) *http.Server {
+	return &http.Server{
 		Addr:           addr,
 		ReadTimeout:    5 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		IdleTimeout:    60 * time.Second,
-		Handler:        middleware(mux),
-		TLSConfig:      cfg,
+		Handler:        m.HTTPHandler(nil),
+		ErrorLog:       logger,
+		MaxHeaderBytes: MaxHeaderBytes,
+	}
+}
+
+// ListenAndServeHTTPRedirect listens on port 80 and redirects all requests
+// to HTTPS on the port of httpsAddr.
+func ListenAndServeHTTPRedirect(httpsAddr string) error {
+	return newRedirectServer(":http", httpsAddr).ListenAndServe()
+}
+
+// newRedirectServer returns a plain HTTP server on addr permanently
+// redirecting all requests to HTTPS on the port of httpsAddr.
+func newRedirectServer(addr, httpsAddr string) *http.Server {
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil || port == "443" || port == "https" {
+		port = ""
+	}
+	return &http.Server{
+		Addr:         addr,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := requestHost(r)
+			if port != "" {
+				host = net.JoinHostPort(host, port)
+			}
+			u := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		}),
 		ErrorLog:       logger,
-		MaxHeaderBytes: (http.DefaultMaxHeaderBytes >> 8),
+		MaxHeaderBytes: MaxHeaderBytes,
+	}
+}
+
+// UnixOptions configures a Unix domain socket listener.
+type UnixOptions struct {
+	// Mode, if non-zero, sets the socket file permissions, e.g. 0660 to
+	// allow access by a reverse proxy in the same group.
+	Mode os.FileMode
+}
+
+// ListenAndServeUnix serves mux over plain HTTP on the Unix domain socket
+// c.UnixSocket, for use behind a local TLS-terminating reverse proxy. The
+// server is configured by c as by ListenAndServe, except for TLS. An existing
+// socket file is replaced, and the socket is removed on shutdown.
+func ListenAndServeUnix(mux *http.ServeMux, c *Config) error {
+	l, err := listenUnix(c.UnixSocket, c.Unix)
+	if err != nil {
+		return err
 	}
 
+	setHeaderPolicy(c.CSP, c.AllowedMethods)
+	defer reloadOnHangup(c)()
+
+	s := newServer(mux, c, nil)
 	defer s.Close()
-	log.Printf("listen: %s", addr)
-	go func() { errc <- s.ListenAndServeTLS("", "") }()
+	done := shutdownOnSignal(s)
+
+	log.Printf("listen: unix:%s", c.UnixSocket)
+	if err := s.Serve(connMetrics.Listener(l)); err != http.ErrServerClosed {
+		return err
+	}
+	return shutdownError(<-done)
+}
+
+// listenUnix listens on the Unix domain socket at socketPath, replacing a
+// stale socket file. The file is removed when the listener is closed.
+func listenUnix(socketPath string, opts UnixOptions) (net.Listener, error) {
+	if fi, err := os.Stat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(socketPath)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(true)
+	if opts.Mode != 0 {
+		if err := os.Chmod(socketPath, opts.Mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// UnixSocketPath returns the socket path of a listen address of the form
+// "unix:/run/site.sock", and whether addr has that form.
+func UnixSocketPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, "unix:")
+}
+
+// newServer returns an HTTP server for mux using the TLS configuration cfg,
+// which may be nil.
+func newServer(mux *http.ServeMux, c *Config, cfg *tls.Config) *http.Server {
+	if cfg != nil {
+		cfg.MinVersion = c.TLSMinVersion
+		if cfg.MinVersion == 0 {
+			cfg.MinVersion = tls.VersionTLS13
+		}
+	}
+	var h http.Handler = mux
+	if c.Maintenance.File != "" {
+		opts := c.Maintenance
+		if opts.Exempt == nil {
+			opts.Exempt = []string{c.HealthPath, c.ReadyPath, acmeChallengePrefix}
+			if c.HealthPath == "" {
+				opts.Exempt[0] = DefaultHealthPath
+			}
+			if c.ReadyPath == "" {
+				opts.Exempt[1] = DefaultReadyPath
+			}
+		}
+		h = Maintenance(opts)(h)
+	}
+	if c.NoIndex {
+		h = NoIndex()(h)
+	}
+	h = ServerHeader(c.ServerHeader)(h)
+	h = NewHandler(h, c.Middleware...)
+	if metrics != nil {
+		h = metrics.Collect(h)
+	}
+	t := c.Timeouts.withDefaults()
+	return &http.Server{
+		Addr:           c.Addr,
+		ReadTimeout:    t.Read,
+		WriteTimeout:   t.Write,
+		IdleTimeout:    t.Idle,
+		Handler:        h,
+		TLSConfig:      cfg,
+		ConnState:      connMetrics.ConnState,
+		ErrorLog:       logger,
+		MaxHeaderBytes: MaxHeaderBytes,
+	}
+}
+
+// ServerTimeouts are the timeouts of the HTTPS server. See http.Server.
+type ServerTimeouts struct {
+	Read  time.Duration // Time to read the request, including the body
+	Write time.Duration // Time to write the response
+	Idle  time.Duration // Time to wait for the next request on a connection
+}
+
+// DefaultServerTimeouts are the timeouts used when none are given.
+var DefaultServerTimeouts = ServerTimeouts{
+	Read:  5 * time.Second,
+	Write: 10 * time.Second,
+	Idle:  60 * time.Second,
+}
+
+// withDefaults returns t with zero timeouts replaced by the defaults.
+func (t ServerTimeouts) withDefaults() ServerTimeouts {
+	if t.Read == 0 {
+		t.Read = DefaultServerTimeouts.Read
+	}
+	if t.Write == 0 {
+		t.Write = DefaultServerTimeouts.Write
+	}
+	if t.Idle == 0 {
+		t.Idle = DefaultServerTimeouts.Idle
+	}
+	return t
+}
 
+// ParseTLSVersion returns the TLS version constant for a version string such
+// as "1.2" or "1.3".
+func ParseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q (want 1.2 or 1.3)", v)
+}
+
+// reloadOnHangup reloads c each time the process receives SIGHUP. The
+// returned function stops reloading.
+func reloadOnHangup(c *Config) (stop func()) {
 	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, os.Interrupt)
+	done := make(chan struct{})
+	signal.Notify(ch, syscall.SIGHUP)
 	go func() {
-		sig := <-ch
-		log.Printf("signal %v received; shutting down", sig)
-		os.Exit(0)
+		for {
+			select {
+			case <-ch:
+				go func() {
+					if err := ReloadConfig(c); err != nil {
+						logger.Printf("reload: %v", err)
+						return
+					}
+					logger.Printf("configuration reloaded")
+				}()
+			case <-done:
+				return
+			}
+		}
 	}()
-
-	return <-errc
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
 }
 
-func Server(fsDir, addr, dirCache string, selfSign bool) {
+func Server(c *Config) {
 	mux := http.NewServeMux()
-	fs := http.FileServer(http.Dir(fsDir))
-	mux.Handle("/", http.StripPrefix("/", fs))
+	var root http.FileSystem = http.Dir(c.FSDir)
+	var fsys fs.FS
+	if c.Embedded != nil {
+		fsys = NewAutoFS(c.Embedded, c.FSDir)
+		root = http.FS(fsys)
+	}
+	ctypes := c.ContentTypes
+	if ctypes == nil {
+		ctypes = DefaultContentTypes
+	}
+	favicon := c.Favicon
+	if favicon == "" {
+		favicon = filepath.Join(c.FSDir, "favicon.ico")
+	}
+	// Files such as .git and .env are never served; security.txt is opened
+	// from root beneath .well-known by its own handler.
+	files := NoDotfiles(root)
+	fsrv := http.FileServer(files)
+	if fsys != nil {
+		fsrv = RangeHandler(fsys)
+	}
+	redirects, err := Redirects(c.Redirects)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	var site http.Handler = Apply(
+		CanonicalHost(c.CanonicalHost),
+		NormalisePath(),
+		redirects,
+		Robots(files, c.Robots),
+		Favicon(favicon),
+		ContentTypes(ctypes),
+		TrailingSlash(files, c.StripTrailingSlash),
+	)(http.StripPrefix("/", fsrv))
+	if c.VirtualHosts != nil {
+		routes := map[string]http.Handler{"*": site}
+		for host, h := range c.VirtualHosts {
+			if h == nil {
+				h = site
+			}
+			routes[host] = h
+		}
+		site = VirtualHost(routes)
+	}
+	if c.Watch && c.SelfSign {
+		lr := NewLiveReload()
+		if _, err := lr.Watch(c.FSDir); err != nil {
+			log.Fatalf("%v", err)
+		}
+		site = InjectLiveReload()(site)
+		mux.Handle(LiveReloadPath, lr)
+		mux.Handle(liveReloadScriptPath, lr)
+	}
+	mux.Handle("/", site)
+	registerMounts(mux, c.Mounts)
+	registerHealth(mux, c, &health)
+
+	wk := NewWellKnownMux()
+	if _, ok := c.WellKnown["security.txt"]; !ok {
+		h, err := securityTXTHandler(root, c.SecurityTXT)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		wk.Register("security.txt", h)
+	}
+	for suffix, h := range c.WellKnown {
+		wk.Register(suffix, h)
+	}
+	mux.Handle(WellKnownPrefix, wk)
+
+	if c.Metrics {
+		allow, err := AllowIPs(c.MetricsAllow...)
+		if err != nil {
+			log.Fatalf("metrics: %v", err)
+		}
+		metrics = NewMetrics()
+		mux.Handle(DefaultMetricsPath, allow(metrics))
+	}
+
+	if c.PprofAddr != "" {
+		go func() {
+			logger.Printf("pprof listener %s: %v", c.PprofAddr, ServePprof(c.PprofAddr))
+		}()
+	}
 
-	errc := make(chan error)
-	err := ListenAndServe(mux, addr, dirCache, selfSign)
+	if path, ok := UnixSocketPath(c.Addr); ok && c.UnixSocket == "" {
+		c.UnixSocket = path
+	}
 
-	errc <- fmt.Errorf("ListenAndServe: %v", err)
+	if c.UnixSocket != "" {
+		err = ListenAndServeUnix(mux, c)
+	} else {
+		err = ListenAndServe(mux, c)
+	}
+	if err != nil {
+		log.Fatalf("ListenAndServe: %v", err)
+	}
 }
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a new self-signed certificate and key to the given files.
+func writeKeyPair(t *testing.T, certFile, keyFile string) []byte {
+	t.Helper()
+	cert, err := selfSignedCert(DefaultKeyType)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	der := cert.Certificate[0]
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+	return der
+}
+
+func TestReloadOnHangup(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	old := writeKeyPair(t, certFile, keyFile)
+
+	p, err := NewCertProvider(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer reloadOnHangup(&Config{certs: p})()
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}),
+	)
+	ts.TLS = p.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	served := func() []byte {
+		conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), &tls.Config{
+			ServerName:         "example.com", // Prefer GetCertificate over httptest defaults.
+			InsecureSkipVerify: true,
+		})
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Raw
+	}
+
+	if !bytes.Equal(served(), old) {
+		t.Fatalf("initial certificate not served")
+	}
+
+	cur := writeKeyPair(t, certFile, keyFile)
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(served(), cur) {
+		if time.Now().After(deadline) {
+			t.Fatalf("reloaded certificate not served after SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+	}{
+		{"", tls.VersionTLS13},
+		{"1.2", tls.VersionTLS12},
+		{"1.3", tls.VersionTLS13},
+	}
+
+	for _, tt := range tests {
+		var c Config
+		if tt.version != "" {
+			v, err := ParseTLSVersion(tt.version)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			c.TLSMinVersion = v
+		}
+		s := newServer(http.NewServeMux(), &c, &tls.Config{})
+		if got := s.TLSConfig.MinVersion; got != tt.want {
+			t.Errorf("%q: MinVersion = %x, want %x", tt.version, got, tt.want)
+		}
+	}
+
+	if _, err := ParseTLSVersion("1.0"); err == nil {
+		t.Errorf("expected error for TLS 1.0")
+	}
+}
+
+func TestChallengeServer(t *testing.T) {
+	dir := t.TempDir()
+	client, err := newACMEClient(dir, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	m, err := autocertX509(dir, client, []string{"example.com"})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "token+http-01"), []byte("token.thumbprint"), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	s := newChallengeServer(l.Addr().String(), m)
+	go s.Serve(l)
+	defer s.Close()
+
+	req, err := http.NewRequest("GET", "http://"+l.Addr().String()+"/.well-known/acme-challenge/token", nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	req.Host = "example.com"
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(b) != "token.thumbprint" {
+		t.Errorf("challenge response = %d %q, want 200 %q", resp.StatusCode, b, "token.thumbprint")
+	}
+}
+
+func TestChallengeListenerBusy(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer busy.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- ListenAndServe(http.NewServeMux(), &Config{
+			Addr:     addr,
+			DirCache: t.TempDir(),
+			HTTPAddr: busy.Addr().String(),
+		})
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		select {
+		case err := <-errc:
+			t.Fatalf("ListenAndServe returned: %v", err)
+		default:
+		}
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TLS server not listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Give the challenge listener time to fail.
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-errc:
+		t.Fatalf("ListenAndServe returned: %v", err)
+	default:
+	}
+}
+
+func TestReloadConfigDuringRequests(t *testing.T) {
+	defer setHeaderPolicy(nil, nil)
+
+	p, err := NewCertProvider("", "", "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	c := &Config{certs: p}
+	defer reloadOnHangup(c)()
+
+	ts := httptest.NewUnstartedServer(NewHandler(http.NewServeMux()))
+	ts.TLS = p.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	alt := map[string][]string{"default-src": {cspSelf}}
+	want := map[string]bool{DefaultCSP: true, buildCSP(alt): true}
+
+	errc := make(chan error, 8)
+	for i := 0; i < cap(errc); i++ {
+		go func() {
+			for j := 0; j < 20; j++ {
+				resp, err := ts.Client().Get(ts.URL)
+				if err != nil {
+					errc <- err
+					return
+				}
+				resp.Body.Close()
+				if got := resp.Header.Get("Content-Security-Policy"); !want[got] {
+					errc <- fmt.Errorf("unexpected CSP %q", got)
+					return
+				}
+			}
+			errc <- nil
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := ReloadConfig(&Config{CSP: alt}); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatalf("%v", err)
+		}
+	}
+
+	for i := 0; i < cap(errc); i++ {
+		if err := <-errc; err != nil {
+			t.Errorf("%v", err)
+		}
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "site.sock")
+	if path, ok := UnixSocketPath("unix:" + socketPath); !ok || path != socketPath {
+		t.Fatalf("UnixSocketPath = %q, %v, want %q, true", path, ok, socketPath)
+	}
+
+	l, err := listenUnix(socketPath, UnixOptions{Mode: 0660})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	fi, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0660 {
+		t.Errorf("socket mode = %v, want 0660", mode)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	s := newServer(mux, &Config{Addr: socketPath}, nil)
+	go s.Serve(l)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(b) != "ok" {
+		t.Errorf("got %d %q, want %d %q", resp.StatusCode, b, http.StatusOK, "ok")
+	}
+
+	s.Close()
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("socket file not removed on close: %v", err)
+	}
+}
+
+func TestRedirectServer(t *testing.T) {
+	tests := []struct {
+		httpsAddr string
+		target    string
+		want      string
+	}{
+		{":4433", "http://example.com/a?b=c", "https://example.com:4433/a?b=c"},
+		{":443", "http://example.com:80/", "https://example.com/"},
+	}
+	for _, tt := range tests {
+		s := newRedirectServer(":0", tt.httpsAddr)
+		w := httptest.NewRecorder()
+		s.Handler.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: got status %d, want %d", tt.target, w.Code, http.StatusMovedPermanently)
+		}
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: got Location %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	s := newServer(http.NewServeMux(), &Config{}, nil)
+	if s.ReadTimeout != DefaultServerTimeouts.Read || s.WriteTimeout != DefaultServerTimeouts.Write || s.IdleTimeout != DefaultServerTimeouts.Idle {
+		t.Errorf("got timeouts %v/%v/%v, want defaults", s.ReadTimeout, s.WriteTimeout, s.IdleTimeout)
+	}
+
+	s = newServer(http.NewServeMux(), &Config{Timeouts: ServerTimeouts{Read: 50 * time.Millisecond}}, nil)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	go s.Serve(l)
+	defer s.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET / HTTP/1.1\r\n")
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	t0 := time.Now()
+	io.Copy(io.Discard, c)
+	if took := time.Since(t0); took > time.Second {
+		t.Errorf("slow request not closed after read timeout (took %v)", took)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	started := make(chan struct{})
+	s := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				close(started)
+				<-r.Context().Done()
+			}
+		}),
+		ConnState: connMetrics.ConnState,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	go s.Serve(l)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := http.Get("http://" + l.Addr().String() + "/slow")
+		errc <- err
+	}()
+	<-started
+	if got := GracefulShutdown(s, 50*time.Millisecond); got != ShutdownForced {
+		t.Errorf("with a slow request: got %v, want forced", got)
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("slow request completed, want connection closed")
+	}
+	if !strings.Contains(buf.String(), "1 connections still active") {
+		t.Errorf("log %q does not report the active connection", buf.String())
+	}
+	if err := shutdownError(ShutdownForced); err != ErrShutdownForced {
+		t.Errorf("shutdownError(ShutdownForced) = %v, want ErrShutdownForced", err)
+	}
+
+	idle := &http.Server{Handler: http.NotFoundHandler()}
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	go idle.Serve(l)
+	if got := GracefulShutdown(idle, time.Second); got != ShutdownClean {
+		t.Errorf("without requests: got %v, want clean", got)
+	}
+}
+
+func TestListenAndServeUnixConfig(t *testing.T) {
+	defer health.draining.Store(false)
+	defer setHeaderPolicy(nil, nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "upstream")
+		io.WriteString(w, "ok")
+	})
+	socketPath := filepath.Join(t.TempDir(), "site.sock")
+	c := &Config{
+		UnixSocket:     socketPath,
+		ServerHeader:   "site",
+		NoIndex:        true,
+		CSP:            map[string][]string{"default-src": {cspSelf}},
+		AllowedMethods: []string{"GET"},
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- ListenAndServeUnix(mux, c) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return new(net.Dialer).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	var resp *http.Response
+	var err error
+	for i := 0; i < 100; i++ {
+		if resp, err = client.Get("http://unix/"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	resp.Body.Close()
+
+	if got := resp.Header.Get("Server"); got != "site" {
+		t.Errorf("Server = %q, want site", got)
+	}
+	if got := resp.Header.Get("X-Robots-Tag"); got == "" {
+		t.Errorf("X-Robots-Tag missing; NoIndex not applied")
+	}
+	if got, want := resp.Header.Get("Content-Security-Policy"), buildCSP(c.CSP); got != want {
+		t.Errorf("Content-Security-Policy = %q, want %q", got, want)
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("ListenAndServeUnix: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("ListenAndServeUnix did not return on SIGTERM")
+	}
+}
+
+func TestListenAndServeClientCAError(t *testing.T) {
+	c := &Config{
+		Addr:         "127.0.0.1:0",
+		SelfSign:     true,
+		ClientCAFile: filepath.Join(t.TempDir(), "missing.pem"),
+	}
+	if err := ListenAndServe(http.NewServeMux(), c); err == nil {
+		t.Errorf("expected error for missing client CA file")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// SitemapOptions configures SitemapHandler.
+type SitemapOptions struct {
+	IncludeAll bool    // List all files, not only HTML documents
+	ChangeFreq string  // Change frequency of each page, e.g. "weekly"
+	Priority   float64 // Priority of each page, from 0.0 to 1.0; zero omits it
+}
+
+type sitemapURL struct {
+	Loc        string  `xml:"loc"`
+	LastMod    string  `xml:"lastmod,omitempty"`
+	ChangeFreq string  `xml:"changefreq,omitempty"`
+	Priority   float64 `xml:"priority,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// SitemapHandler returns a handler serving a sitemap (sitemaps.org) of the
+// HTML documents in fsys, at URLs relative to baseURL, such as
+// "https://example.com". Files and directories whose names begin with '.'
+// are excluded, and index.html is listed as its directory.
+func SitemapHandler(baseURL string, fsys fs.FS, opts SitemapOptions) http.HandlerFunc {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		set := sitemapURLSet{}
+		err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if name != "." && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			ext := path.Ext(name)
+			if !opts.IncludeAll && ext != ".html" && ext != ".htm" {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			loc := "/" + name
+			if path.Base(name) == "index.html" {
+				loc = strings.TrimSuffix(loc, "index.html")
+			}
+			set.URLs = append(set.URLs, sitemapURL{
+				Loc:        baseURL + loc,
+				LastMod:    fi.ModTime().UTC().Format(time.RFC3339),
+				ChangeFreq: opts.ChangeFreq,
+				Priority:   opts.Priority,
+			})
+			return nil
+		})
+		if err != nil {
+			logger.Printf("sitemap: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(set); err != nil {
+			logger.Printf("sitemap: %v", err)
+		}
+	}
+}
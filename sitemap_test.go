@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestSitemapHandler(t *testing.T) {
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":          {ModTime: mtime},
+		"about/index.html":    {ModTime: mtime},
+		"posts/hello.html":    {ModTime: mtime},
+		"style.css":           {ModTime: mtime},
+		".git/index.html":     {ModTime: mtime},
+		"posts/.draft.html":   {ModTime: mtime},
+		"posts/notes/a.htm":   {ModTime: mtime},
+		"posts/notes/b.json":  {ModTime: mtime},
+		"posts/notes/.hidden": {ModTime: mtime},
+	}
+
+	get := func(opts SitemapOptions) sitemapURLSet {
+		t.Helper()
+		w := httptest.NewRecorder()
+		SitemapHandler("https://example.com/", fsys, opts)(w, httptest.NewRequest("GET", "/sitemap.xml", nil))
+		if got := w.Header().Get("Content-Type"); got != "application/xml; charset=utf-8" {
+			t.Errorf("got Content-Type %q", got)
+		}
+		var set sitemapURLSet
+		if err := xml.Unmarshal(w.Body.Bytes(), &set); err != nil {
+			t.Fatalf("%v: %s", err, w.Body.String())
+		}
+		return set
+	}
+
+	set := get(SitemapOptions{ChangeFreq: "weekly", Priority: 0.5})
+	var locs []string
+	for _, u := range set.URLs {
+		locs = append(locs, u.Loc)
+		if u.LastMod != "2024-05-01T12:00:00Z" || u.ChangeFreq != "weekly" || u.Priority != 0.5 {
+			t.Errorf("%s: got %+v", u.Loc, u)
+		}
+	}
+	want := []string{
+		"https://example.com/about/",
+		"https://example.com/",
+		"https://example.com/posts/hello.html",
+		"https://example.com/posts/notes/a.htm",
+	}
+	if !reflect.DeepEqual(locs, want) {
+		t.Errorf("got URLs %q, want %q", locs, want)
+	}
+
+	if n := len(get(SitemapOptions{IncludeAll: true}).URLs); n != 6 {
+		t.Errorf("IncludeAll: got %d URLs, want 6", n)
+	}
+}
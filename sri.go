@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+)
+
+// ComputeSRI returns the Subresource Integrity metadata for the named file in
+// fsys, in the form "sha384-<base64>" used by integrity attributes.
+func ComputeSRI(fsys fs.FS, path string) (string, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha512.New384()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha384-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// sriHashes returns the SRI hash of each regular file in fsys, by path.
+// The .br and .gz copies written by the compress subcommand are skipped:
+// integrity is checked against the decoded content, which is that of the
+// original file.
+func sriHashes(fsys fs.FS) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if ext := path.Ext(name); ext == ".br" || ext == ".gz" {
+			return nil
+		}
+		sri, err := ComputeSRI(fsys, name)
+		if err != nil {
+			return err
+		}
+		hashes[name] = sri
+		return nil
+	})
+	return hashes, err
+}
+
+// sriMain implements the sri subcommand, printing a JSON object mapping each
+// file under the static directory to its SRI hash.
+func sriMain(args []string) int {
+	fl := flag.NewFlagSet("sri", flag.ExitOnError)
+	dir := fl.String("fsdir", "static", "file system directory")
+	fl.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: site sri [-fsdir dir]\n")
+		fl.PrintDefaults()
+	}
+	fl.Parse(args)
+
+	hashes, err := sriHashes(os.DirFS(*dir))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "site sri: %v\n", err)
+		return 1
+	}
+
+	b, err := json.MarshalIndent(hashes, "", "\t")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "site sri: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%s\n", b)
+	return 0
+}
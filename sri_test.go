@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSRI(t *testing.T) {
+	fsys := fstest.MapFS{
+		"hello.js":          {Data: []byte("alert('Hello, world.');")},
+		"hello.js.br":       {Data: []byte("brotli")},
+		"hello.js.gz":       {Data: []byte("gzip")},
+		"css/empty.css":     {Data: nil},
+		"css/empty.css.gz":  {Data: []byte("gzip")},
+		"css/empty.css.map": {Data: []byte("{}")},
+	}
+
+	// The example of the Subresource Integrity specification.
+	const want = "sha384-H8BRh8j48O9oYatfu5AZzq6A9RINhZO5H16dQZngK7T62em8MUt1FLm52t+eX6xO"
+	if got, err := ComputeSRI(fsys, "hello.js"); err != nil || got != want {
+		t.Errorf("ComputeSRI = %q, %v, want %q", got, err, want)
+	}
+
+	hashes, err := sriHashes(fsys)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	wantHashes := map[string]string{
+		"hello.js":          want,
+		"css/empty.css":     "sha384-OLBgp1GsljhM2TJ+sbHjaiH9txEUvgdDTAzHv2P24donTt6/529l+9Ua0vFImLlb",
+		"css/empty.css.map": "sha384-0qI7x4Pjqjj0AeE8dIhQUTfElUp/2IMx8Vl8X/cREdyAfHNwpbKCxtpUHFbt5p8w",
+	}
+	if !reflect.DeepEqual(hashes, wantHashes) {
+		t.Errorf("sriHashes = %v, want %v", hashes, wantHashes)
+	}
+}
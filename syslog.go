@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/syslog"
+	"strings"
+)
+
+// syslogFacilities maps facility names to their syslog priorities.
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// ParseSyslogFacility returns the syslog facility with the given name, such
+// as "daemon" or "local0".
+func ParseSyslogFacility(name string) (syslog.Priority, error) {
+	f, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("syslog: unknown facility %q", name)
+	}
+	return f, nil
+}
+
+// DialSyslog connects to the syslog service at addr, logging messages at
+// level Info to facility with the given tag. An addr of "local" selects the
+// local syslog service; otherwise it has the form "udp://host:port",
+// "tcp://host:port" or "host:port", which uses UDP.
+func DialSyslog(addr string, facility syslog.Priority, tag string) (*syslog.Writer, error) {
+	network := "udp"
+	switch {
+	case addr == "local":
+		network, addr = "", ""
+	case strings.HasPrefix(addr, "udp://"):
+		addr = strings.TrimPrefix(addr, "udp://")
+	case strings.HasPrefix(addr, "tcp://"):
+		network, addr = "tcp", strings.TrimPrefix(addr, "tcp://")
+	}
+	w, err := syslog.Dial(network, addr, facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("syslog: %v", err)
+	}
+	return w, nil
+}
+
+// NewSyslogLogger returns a logger writing to the syslog service at addr, as
+// for DialSyslog, at level Info to facility with the given tag.
+func NewSyslogLogger(addr, tag string, facility syslog.Priority) (*log.Logger, error) {
+	w, err := DialSyslog(addr, facility, tag)
+	if err != nil {
+		return nil, err
+	}
+	return log.New(w, "", 0), nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log/syslog"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialSyslog(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer pc.Close()
+
+	w, err := DialSyslog("udp://"+pc.LocalAddr().String(), syslog.LOG_LOCAL0, "site")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("GET /index.html 200\n")); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	msg := string(buf[:n])
+	// LOG_LOCAL0|LOG_INFO is 16<<3 | 6.
+	if !strings.HasPrefix(msg, "<134>") || !strings.Contains(msg, " site[") || !strings.Contains(msg, "GET /index.html 200") {
+		t.Errorf("unexpected syslog message %q", msg)
+	}
+}
+
+func TestDialSyslogUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	if _, err := DialSyslog("tcp://"+addr, syslog.LOG_DAEMON, "site"); err == nil {
+		t.Errorf("expected error dialing closed port %s", addr)
+	}
+}
+
+func TestNewSyslogLogger(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer pc.Close()
+	addr := "udp://" + pc.LocalAddr().String()
+
+	tests := []struct {
+		tag      string
+		facility syslog.Priority
+		prefix   string
+		wantTag  string
+	}{
+		// LOG_LOCAL3|LOG_INFO is 19<<3 | 6.
+		{"web", syslog.LOG_LOCAL3, "<158>", "web"},
+		// LOG_DAEMON|LOG_INFO is 3<<3 | 6.
+		{"site", syslog.LOG_DAEMON, "<30>", "site"},
+		// An empty tag selects the program name.
+		{"", syslog.LOG_USER, "<14>", os.Args[0]},
+	}
+	buf := make([]byte, 1024)
+	for _, tt := range tests {
+		l, err := NewSyslogLogger(addr, tt.tag, tt.facility)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		l.Printf("served %s", "/index.html")
+		l.Writer().(*syslog.Writer).Close()
+
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		msg := string(buf[:n])
+		if !strings.HasPrefix(msg, tt.prefix) || !strings.Contains(msg, " "+tt.wantTag+"[") || !strings.HasSuffix(msg, ": served /index.html\n") {
+			t.Errorf("tag %q, facility %d: got %q, want priority %s and tag %q", tt.tag, tt.facility, msg, tt.prefix, tt.wantTag)
+		}
+	}
+
+	if _, err := NewSyslogLogger(addr, "web", -1<<3); err == nil {
+		t.Errorf("expected error for invalid facility")
+	}
+}
+
+func TestParseSyslogFacility(t *testing.T) {
+	if f, err := ParseSyslogFacility("Local3"); err != nil || f != syslog.LOG_LOCAL3 {
+		t.Errorf("ParseSyslogFacility(Local3) = %v, %v", f, err)
+	}
+	if _, err := ParseSyslogFacility("local8"); err == nil {
+		t.Errorf("expected error for unknown facility")
+	}
+}
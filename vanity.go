@@ -0,0 +1,49 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// GoImport maps a Go module path prefix, such as example.com/tool, to the
+// repository it is served from.
+type GoImport struct {
+	Prefix  string // Import path prefix, including the host
+	VCS     string // Version control system, e.g. "git"
+	RepoURL string // Repository root URL
+}
+
+var goImportTmpl = template.Must(template.New("go-import").Parse(`<!DOCTYPE html>
+<html><head>
+<meta name="go-import" content="{{.Prefix}} {{.VCS}} {{.RepoURL}}">
+</head><body>go get {{.Prefix}}</body></html>
+`))
+
+// GoImports returns a middleware answering `go get` discovery requests
+// (?go-get=1) for packages under the given prefixes with a go-import meta tag,
+// so that a custom domain may be used as a Go import path. The request host
+// is matched in lower case and without the port. Other requests are passed
+// through.
+func GoImports(imports ...GoImport) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("go-get") != "1" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			ipath := strings.TrimSuffix(requestHost(r)+r.URL.Path, "/")
+			for _, imp := range imports {
+				if ipath != imp.Prefix && !strings.HasPrefix(ipath, imp.Prefix+"/") {
+					continue
+				}
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				if err := goImportTmpl.Execute(w, imp); err != nil {
+					logger.Printf("go-import: %v", err)
+				}
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoImports(t *testing.T) {
+	h := GoImports(GoImport{
+		Prefix:  "example.com/tool",
+		VCS:     "git",
+		RepoURL: "https://github.com/example/tool",
+	})(http.NotFoundHandler())
+
+	want := `<meta name="go-import" content="example.com/tool git https://github.com/example/tool">`
+	for _, target := range []string{
+		"https://example.com/tool/cmd/run?go-get=1",
+		"https://example.com:4433/tool?go-get=1",
+		"https://Example.COM./tool/cmd?go-get=1",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) {
+			t.Errorf("%s: got %d %q, want body containing %q", target, w.Code, w.Body.String(), want)
+		}
+	}
+
+	for _, target := range []string{
+		"https://example.com/tool/cmd/run",
+		"https://example.com/toolbox?go-get=1",
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d, want %d", target, w.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVersionString(t *testing.T) {
+	got := versionString()
+	if !strings.HasPrefix(got, "site ") || strings.Contains(got, "site  ") {
+		t.Errorf("versionString() = %q, want build information", got)
+	}
+
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+	version, commit, date = "v1.2.0", "0123abc", "2024-05-01T12:00:00Z"
+	if got, want := versionString(), "site v1.2.0 (commit 0123abc, built 2024-05-01T12:00:00Z, "; !strings.HasPrefix(got, want) {
+		t.Errorf("versionString() = %q, want prefix %q", got, want)
+	}
+}
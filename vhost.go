@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// VirtualHost returns a handler dispatching requests to the handler in routes
+// for the request host, compared case-insensitively and without the port.
+// The "*" route, if any, serves hosts without a route of their own; other
+// requests are answered with 404 Not Found.
+func VirtualHost(routes map[string]http.Handler) http.Handler {
+	hosts := make(map[string]http.Handler, len(routes))
+	for host, h := range routes {
+		hosts[strings.ToLower(host)] = h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := hosts[requestHost(r)]
+		if !ok {
+			h, ok = hosts["*"]
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// routedHosts returns the hosts routed by routes, other than "*", in lower
+// case and sorted order.
+func routedHosts(routes map[string]http.Handler) []string {
+	hosts := make([]string, 0, len(routes))
+	for host := range routes {
+		if host != "*" {
+			hosts = append(hosts, strings.ToLower(host))
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// CanonicalHost returns a middleware permanently redirecting requests for the
+// "www." form of host to host, or for host without "www." to the "www." form
+// if host has it, preserving the path and query. Other hosts, e.g. those of
+// other sites served, are not redirected. An empty host disables the redirect.
+func CanonicalHost(host string) Middleware {
+	if host == "" {
+		return func(h http.Handler) http.Handler { return h }
+	}
+	host = strings.ToLower(host)
+	alias := "www." + host
+	if h, ok := strings.CutPrefix(host, "www."); ok {
+		alias = h
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if requestHost(r) != alias {
+				h.ServeHTTP(w, r)
+				return
+			}
+			target := host
+			if _, port, err := net.SplitHostPort(r.Host); err == nil {
+				target = net.JoinHostPort(host, port)
+			}
+			http.Redirect(w, r, "https://"+target+r.URL.RequestURI(), http.StatusMovedPermanently)
+		})
+	}
+}
+
+// requestHost returns the host of r in lower case, without the port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVirtualHost(t *testing.T) {
+	text := func(s string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, s)
+		})
+	}
+	routes := map[string]http.Handler{
+		"example.com":      text("example"),
+		"Blog.Example.com": text("blog"),
+	}
+
+	tests := []struct {
+		host   string
+		status int
+		body   string
+	}{
+		{"example.com", http.StatusOK, "example"},
+		{"EXAMPLE.COM:4433", http.StatusOK, "example"},
+		{"blog.example.com", http.StatusOK, "blog"},
+		{"other.example", http.StatusNotFound, ""},
+	}
+	check := func(h http.Handler, host string, status int, body string) {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != status || (status == http.StatusOK && w.Body.String() != body) {
+			t.Errorf("host %q: got %d %q, want %d %q", host, w.Code, w.Body.String(), status, body)
+		}
+	}
+	h := VirtualHost(routes)
+	for _, tt := range tests {
+		check(h, tt.host, tt.status, tt.body)
+	}
+
+	routes["*"] = text("default")
+	check(VirtualHost(routes), "other.example", http.StatusOK, "default")
+}
+
+func TestCanonicalHost(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		canonical, url, location string
+	}{
+		{"bwsd.net", "https://www.bwsd.net/a/b.html?q=1", "https://bwsd.net/a/b.html?q=1"},
+		{"www.bwsd.net", "https://bwsd.net/a/b.html?q=1", "https://www.bwsd.net/a/b.html?q=1"},
+		{"bwsd.net", "https://WWW.bwsd.net:8443/", "https://bwsd.net:8443/"},
+		{"bwsd.net", "https://bwsd.net/a/b.html?q=1", ""},
+		{"www.bwsd.net", "https://www.bwsd.net/", ""},
+		{"bwsd.net", "https://blog.bwsd.net/", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		CanonicalHost(tt.canonical)(next).ServeHTTP(w, httptest.NewRequest("GET", tt.url, nil))
+		if tt.location == "" {
+			if w.Code != http.StatusOK {
+				t.Errorf("%s to %s: got status %d, want no redirect", tt.url, tt.canonical, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s to %s: got %d %q, want 301 %q", tt.url, tt.canonical, w.Code, w.Header().Get("Location"), tt.location)
+		}
+	}
+}
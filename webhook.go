@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// MaxWebhookBody is the size, in bytes, of the largest request body verified
+// by VerifyWebhookSignature. Larger requests are rejected with 413 Request
+// Entity Too Large.
+var MaxWebhookBody int64 = 1 << 20
+
+// HashAlgo is the hash function of an HMAC webhook signature.
+type HashAlgo int
+
+const (
+	SHA256 HashAlgo = iota // HMAC-SHA256, e.g. GitHub's X-Hub-Signature-256
+	SHA1                   // HMAC-SHA1, e.g. GitHub's legacy X-Hub-Signature
+	SHA512                 // HMAC-SHA512
+)
+
+// String returns the name of the algorithm, as used to prefix signatures.
+func (a HashAlgo) String() string {
+	switch a {
+	case SHA1:
+		return "sha1"
+	case SHA512:
+		return "sha512"
+	}
+	return "sha256"
+}
+
+func (a HashAlgo) new() func() hash.Hash {
+	switch a {
+	case SHA1:
+		return sha1.New
+	case SHA512:
+		return sha512.New
+	}
+	return sha256.New
+}
+
+// VerifyWebhookSignature returns a middleware verifying that the request body
+// is signed with secret: the named header must hold the hex-encoded HMAC of
+// the body, optionally prefixed by the algorithm name and '=', e.g.
+// "sha256=...". Requests with a missing or incorrect signature are rejected
+// with 403 Forbidden. The body remains readable by the next handler.
+func VerifyWebhookSignature(secret string, headerName string, algo HashAlgo) Middleware {
+	prefix := algo.String() + "="
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxWebhookBody))
+			if err != nil {
+				status := http.StatusBadRequest
+				if _, ok := err.(*http.MaxBytesError); ok {
+					status = http.StatusRequestEntityTooLarge
+				}
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			mac := hmac.New(algo.new(), []byte(secret))
+			mac.Write(body)
+			want := hex.EncodeToString(mac.Sum(nil))
+			got := strings.ToLower(strings.TrimPrefix(r.Header.Get(headerName), prefix))
+			if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	const secret = "It's a Secret to Everybody"
+	const payload = "Hello, World!"
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	var got string
+	h := VerifyWebhookSignature(secret, "X-Hub-Signature-256", SHA256)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	}))
+
+	tests := []struct {
+		signature string
+		body      string
+		status    int
+	}{
+		{"sha256=" + sig, payload, http.StatusOK},
+		{sig, payload, http.StatusOK},
+		{"sha256=" + strings.ToUpper(sig), payload, http.StatusOK},
+		{"sha256=" + sig, payload + ".", http.StatusForbidden},
+		{"sha1=" + sig, payload, http.StatusForbidden},
+		{"", payload, http.StatusForbidden},
+		{"sha256=" + sig, strings.Repeat("x", int(MaxWebhookBody)+1), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		got = ""
+		r := httptest.NewRequest("POST", "/hooks", strings.NewReader(tt.body))
+		if tt.signature != "" {
+			r.Header.Set("X-Hub-Signature-256", tt.signature)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("signature %.16q: got status %d, want %d", tt.signature, w.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && got != tt.body {
+			t.Errorf("signature %.16q: handler read body %q, want %q", tt.signature, got, tt.body)
+		}
+	}
+}
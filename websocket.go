@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// WebSocketUpgrade returns a middleware passing WebSocket opening handshakes
+// (RFC 6455, section 4) to upgrader, in place of the rest of the chain. It
+// should be applied before middlewares which buffer or rewrite responses,
+// such as compression and caching, as these break the upgrade.
+//
+// Handshakes from browsers on origins other than the HTTPS origin of the
+// request host are refused with 403 Forbidden, to prevent cross-site WebSocket hijacking.
+func WebSocketUpgrade(upgrader func(w http.ResponseWriter, r *http.Request)) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isWebSocket(r) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if origin := r.Header.Get("Origin"); origin != "" && !allowedOrigin(origin, requestHost(r)) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			upgrader(w, r)
+		})
+	}
+}
+
+// isWebSocket reports whether r requests an upgrade to the WebSocket
+// protocol.
+func isWebSocket(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") &&
+		headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// headerHasToken reports whether the comma-separated header key contains
+// token, compared case-insensitively.
+func headerHasToken(h http.Header, key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowedOrigin reports whether origin is the HTTPS origin of host.
+func allowedOrigin(origin, host string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != "https" {
+		return false
+	}
+	return strings.EqualFold(u.Hostname(), host)
+}
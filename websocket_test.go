@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebSocketUpgrade(t *testing.T) {
+	h := WebSocketUpgrade(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusSwitchingProtocols)
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		upgrade bool
+		origin  string
+		want    int
+	}{
+		{false, "", http.StatusOK},
+		{true, "", http.StatusSwitchingProtocols},
+		{true, "https://example.com", http.StatusSwitchingProtocols},
+		{true, "https://evil.example", http.StatusForbidden},
+		{true, "https://EXAMPLE.com", http.StatusSwitchingProtocols},
+		{true, "http://example.com", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "https://example.com:4433/ws", nil)
+		if tt.upgrade {
+			r.Header.Set("Connection", "keep-alive, Upgrade")
+			r.Header.Set("Upgrade", "websocket")
+		}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("upgrade=%v origin=%q: got status %d, want %d", tt.upgrade, tt.origin, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// WellKnownPrefix is the path prefix of well-known URIs (RFC 8615).
+const WellKnownPrefix = "/.well-known/"
+
+// WellKnownMux serves well-known URIs, such as /.well-known/security.txt,
+// independently of the file system. Requests for unregistered URIs are
+// answered with 404 Not Found.
+type WellKnownMux struct {
+	*http.ServeMux
+}
+
+// NewWellKnownMux returns an empty WellKnownMux.
+func NewWellKnownMux() *WellKnownMux {
+	return &WellKnownMux{http.NewServeMux()}
+}
+
+// Register registers h for the well-known URI /.well-known/<suffix>. A suffix
+// ending in '/' matches all URIs beneath it.
+func (m *WellKnownMux) Register(suffix string, h http.Handler) {
+	m.Handle(WellKnownPrefix+strings.TrimPrefix(suffix, "/"), h)
+}
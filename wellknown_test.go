@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWellKnownMux(t *testing.T) {
+	wk := NewWellKnownMux()
+	wk.Register("change-password", http.RedirectHandler("/account/password", http.StatusFound))
+	wk.Register("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/.well-known/change-password", http.StatusFound},
+		{"/.well-known/test", http.StatusOK},
+		{"/.well-known/unregistered", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		wk.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+}
@@ -1,39 +1,252 @@
 package main
 
-// TODO: implement OCSP stapling for acme/autocert. See: golang.org/issue/51064
-// TODO: implement DNS-01 challenges. See: RFC 8555, 8.4
-
 import (
 	"context"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
 	"math/big"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
 
-func NewX509Certificate(dirCache string, selfSign bool) (*tls.Config, error) {
-	if !selfSign {
-		m, err := autocertX509(dirCache)
+// NewX509Certificate returns a TLS configuration using, in order of
+// preference, an operator-supplied certificate and key pair, a self-signed
+// certificate or one issued by ACME. ACME challenges are answered using
+// HTTP-01 or, if c.DNSProvider is set, DNS-01. Reloadable certificates are
+// recorded in c, for ReloadConfig, as is the ACME manager answering HTTP-01
+// challenges.
+func NewX509Certificate(c *Config) (*tls.Config, error) {
+	acmeIssued := c.CertFile == "" && c.KeyFile == "" && !c.SelfSign
+	if acmeIssued && c.DNSProvider != nil {
+		client, err := newACMEClient(c.DirCache, c.ACMEDirectory)
+		if err != nil {
+			return nil, err
+		}
+		p, err := dns01X509(c.DirCache, client, c.DNSProvider, routedHosts(c.VirtualHosts))
+		if err != nil {
+			return nil, err
+		}
+		c.certs = p
+		return p.TLSConfig(), nil
+	}
+	if acmeIssued {
+		client, err := newACMEClient(c.DirCache, c.ACMEDirectory)
+		if err != nil {
+			return nil, err
+		}
+		m, err := autocertX509(c.DirCache, client, routedHosts(c.VirtualHosts))
 		if err != nil {
 			return nil, err
 		}
-		return m.TLSConfig(), nil
+		c.acme = m
+		return autocertTLSConfig(m), nil
+	}
+	p, err := NewCertProvider(c.CertFile, c.KeyFile, c.KeyType)
+	if err != nil {
+		return nil, err
 	}
-	return selfSignedX509(dirCache)
+	c.certs = p
+	return p.TLSConfig(), nil
+}
+
+// CertProvider holds a certificate which may be replaced while the server is
+// running, without dropping established connections.
+type CertProvider struct {
+	mu    sync.RWMutex
+	certs []tls.Certificate
+	load  func() ([]tls.Certificate, error)
+	stop  func() // stops periodic renewal, if any
 }
 
-func selfSignedX509(dirCache string) (*tls.Config, error) {
-	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// NewCertProvider returns a CertProvider for the PEM-encoded certificate and
+// key pair on disk or, if neither file is given, a self-signed certificate
+// with a key of the given type.
+func NewCertProvider(certFile, keyFile, keyType string) (*CertProvider, error) {
+	p := &CertProvider{
+		load: func() ([]tls.Certificate, error) {
+			return selfSignedCerts(keyType)
+		},
+	}
+	if certFile != "" || keyFile != "" {
+		p.load = oneCert(func() (*tls.Certificate, error) {
+			return fileCert(certFile, keyFile)
+		})
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// oneCert adapts a function loading a single certificate for CertProvider.
+func oneCert(load func() (*tls.Certificate, error)) func() ([]tls.Certificate, error) {
+	return func() ([]tls.Certificate, error) {
+		cert, err := load()
+		if err != nil {
+			return nil, err
+		}
+		return []tls.Certificate{*cert}, nil
+	}
+}
+
+// Reload reads the certificate from disk, or generates a new self-signed
+// certificate. The current certificate is kept on error.
+func (p *CertProvider) Reload() error {
+	certs, err := p.load()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.certs = certs
+	p.mu.Unlock()
+	return nil
+}
+
+// Close stops the periodic renewal of the certificate, if any. The current
+// certificate remains in use.
+func (p *CertProvider) Close() {
+	if p.stop != nil {
+		p.stop()
+	}
+}
+
+// GetCertificate returns the current certificate best suited to the client.
+// It is suitable for use as tls.Config.GetCertificate.
+func (p *CertProvider) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return selectCertificate(hello, p.certs), nil
+}
+
+// MultiCertConfig returns a TLS configuration serving, to each client, the
+// first of certs which it supports, e.g. an ECDSA certificate to modern
+// clients and an RSA certificate to TLS 1.2 clients offering only RSA cipher
+// suites.
+func MultiCertConfig(certs []tls.Certificate) *tls.Config {
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return selectCertificate(hello, certs), nil
+		},
+	}
+}
+
+// selectCertificate returns the first of certs supported by the client, or
+// the first certificate if none is. Certificates valid for the requested
+// server name are preferred; self-signed certificates are valid for none.
+func selectCertificate(hello *tls.ClientHelloInfo, certs []tls.Certificate) *tls.Certificate {
+	if len(certs) == 0 {
+		return nil
+	}
+	if hello != nil && len(certs) > 1 {
+		for _, name := range []string{hello.ServerName, ""} {
+			chi := *hello
+			chi.ServerName = name
+			for i := range certs {
+				if chi.SupportsCertificate(&certs[i]) == nil {
+					return &certs[i]
+				}
+			}
+		}
+	}
+	return &certs[0]
+}
+
+// TLSConfig returns a TLS configuration serving the current certificate.
+func (p *CertProvider) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: p.GetCertificate}
+}
+
+// RequireClientCerts configures cfg to require client certificates signed by
+// one of the PEM-encoded CA certificates in caFile.
+func RequireClientCerts(cfg *tls.Config, caFile string) error {
+	b, err := os.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("x509: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return fmt.Errorf("x509: no CA certificates found in %s", caFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// fileCert loads a PEM-encoded certificate and private key pair from disk.
+func fileCert(certFile, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("x509: both certificate and key files are required")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("x509: %v", err)
+	}
+	return &cert, nil
+}
+
+// KeyTypes lists the key algorithms supported for self-signed certificates.
+// DualKeyType selects both an ECDSA P-256 and an RSA 2048 certificate.
+var KeyTypes = []string{"ecdsa-p256", "ecdsa-p384", "rsa-2048", "rsa-4096", "ed25519", DualKeyType}
+
+// DualKeyType is the key type of a pair of self-signed certificates, for
+// clients supporting ECDSA and those supporting only RSA.
+const DualKeyType = "ecdsa-p256+rsa-2048"
+
+// DefaultKeyType is the key algorithm used when none is specified.
+const DefaultKeyType = "ecdsa-p256"
+
+// generateKey returns a new private key of the named type.
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "", "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "rsa-2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa-4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+	return nil, fmt.Errorf("x509: unknown key type %q (want one of %s)", keyType, strings.Join(KeyTypes, ", "))
+}
+
+// selfSignedCerts returns self-signed certificates with keys of keyType,
+// which may be DualKeyType.
+func selfSignedCerts(keyType string) ([]tls.Certificate, error) {
+	keyTypes := []string{keyType}
+	if keyType == DualKeyType {
+		keyTypes = []string{"ecdsa-p256", "rsa-2048"}
+	}
+	certs := make([]tls.Certificate, len(keyTypes))
+	for i, kt := range keyTypes {
+		cert, err := selfSignedCert(kt)
+		if err != nil {
+			return nil, err
+		}
+		certs[i] = *cert
+	}
+	return certs, nil
+}
+
+func selfSignedCert(keyType string) (*tls.Certificate, error) {
+	priv, err := generateKey(keyType)
 
 	if err != nil {
 		return nil, err
@@ -56,38 +269,79 @@ func selfSignedX509(dirCache string) (*tls.Config, error) {
 		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		IsCA:        true,
 	}
+	// RSA key exchange, in TLS 1.2 and earlier, encrypts with the key.
+	if _, ok := priv.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
 
 	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, priv.Public(), priv)
 	if err != nil {
 		return nil, err
 	}
 
-	cfg := &tls.Config{
-		Certificates: []tls.Certificate{{
-			Certificate: [][]byte{der},
-			PrivateKey:  priv,
-		}},
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  priv,
+	}, nil
+}
+
+// autocertTLSConfig returns a TLS configuration for m which staples OCSP
+// responses to issued certificates. See: golang.org/issue/51064
+func autocertTLSConfig(m *autocert.Manager) *tls.Config {
+	cfg := m.TLSConfig()
+	cfg.GetCertificate = newOCSPStapler(m.GetCertificate).GetCertificate
+	return cfg
+}
+
+// LetsEncryptStagingURL is the Directory endpoint of the Let's Encrypt
+// staging environment, which has relaxed rate limits and issues untrusted
+// certificates.
+const LetsEncryptStagingURL = "https://acme-staging-v02.api.letsencrypt.org/directory"
+
+// ACMEDirectoryURL returns the ACME directory selected by the -acme-staging
+// and -acme-directory flags. An empty result selects Let's Encrypt.
+func ACMEDirectoryURL(staging bool, directory string) (string, error) {
+	if staging && directory != "" {
+		return "", fmt.Errorf("acme: staging and directory URL are mutually exclusive")
 	}
+	if staging {
+		return LetsEncryptStagingURL, nil
+	}
+	return directory, nil
+}
 
-	return cfg, nil
+// newACMEClient returns an ACME client for the CA at directoryURL, or Let's
+// Encrypt if empty, using the account key persisted in dirCache.
+func newACMEClient(dirCache, directoryURL string) (*acme.Client, error) {
+	client, err := PersistentACMEClient(filepath.Join(dirCache, acmeKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	client.DirectoryURL = directoryURL
+	return client, nil
 }
 
-func autocertX509(dirCache string) (*autocert.Manager, error) {
-	m := &autocert.Manager{
-		Prompt: autocert.AcceptTOS,
-		HostPolicy: func(ctx context.Context, host string) error {
-			domain, err := os.Hostname()
-			if err != nil {
-				return err
-			}
-			if !strings.HasSuffix(host, "."+domain) && host != domain {
-				return fmt.Errorf("domain (%q) disallowed by autocert host policy", host)
-			}
-			return nil
-		},
+// HostPolicyFromList returns an autocert.HostPolicy permitting only the given
+// hosts, compared case-insensitively.
+func HostPolicyFromList(hosts ...string) autocert.HostPolicy {
+	allowed := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		allowed[strings.ToLower(strings.TrimSpace(h))] = true
+	}
+	return func(ctx context.Context, host string) error {
+		if !allowed[strings.ToLower(host)] {
+			return fmt.Errorf("domain (%q) disallowed by autocert host policy", host)
+		}
+		return nil
+	}
+}
 
-		Cache:  autocert.DirCache(dirCache),
-		Client: new(acme.Client),
+func autocertX509(dirCache string, client *acme.Client, hosts []string) (*autocert.Manager, error) {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: HostPolicyFromList(hosts...),
+		Cache:      autocert.DirCache(dirCache),
+		Client:     client,
 	}
 
 	return m, nil
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestX509KeyPairFile(t *testing.T) {
+	cfg, err := NewX509Certificate(&Config{
+		CertFile: "testdata/cert.pem",
+		KeyFile:  "testdata/key.pem",
+	})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	cert, err := cfg.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	b, err := os.ReadFile("testdata/cert.pem")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		t.Fatalf("no PEM data in fixture")
+	}
+	want, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if got := cert.Certificate[0]; !bytes.Equal(got, want.Raw) {
+		t.Errorf("loaded certificate does not match fixture")
+	}
+}
+
+func TestX509CertificateReloadable(t *testing.T) {
+	c := &Config{SelfSign: true}
+	if _, err := NewX509Certificate(c); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if c.certs == nil || c.acme != nil {
+		t.Errorf("self-signed: certs = %v, acme = %v, want a CertProvider only", c.certs, c.acme)
+	}
+}
+
+func TestX509KeyPairFileMissingKey(t *testing.T) {
+	if _, err := NewX509Certificate(&Config{CertFile: "testdata/cert.pem"}); err == nil {
+		t.Errorf("expected error for missing key file")
+	}
+}
+
+func TestSelfSignedKeyTypes(t *testing.T) {
+	tests := []struct {
+		keyType string
+		want    any
+	}{
+		{"ecdsa-p256", &ecdsa.PublicKey{}},
+		{"ecdsa-p384", &ecdsa.PublicKey{}},
+		{"rsa-2048", &rsa.PublicKey{}},
+		{"rsa-4096", &rsa.PublicKey{}},
+		{"ed25519", ed25519.PublicKey{}},
+	}
+
+	for _, tt := range tests {
+		cert, err := selfSignedCert(tt.keyType)
+		if err != nil {
+			t.Errorf("%s: %v", tt.keyType, err)
+			continue
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Errorf("%s: %v", tt.keyType, err)
+			continue
+		}
+		if got, want := reflect.TypeOf(leaf.PublicKey), reflect.TypeOf(tt.want); got != want {
+			t.Errorf("%s: public key type = %v, want %v", tt.keyType, got, want)
+		}
+		_, isRSA := leaf.PublicKey.(*rsa.PublicKey)
+		if got := leaf.KeyUsage&x509.KeyUsageKeyEncipherment != 0; got != isRSA {
+			t.Errorf("%s: key encipherment usage = %v, want %v", tt.keyType, got, isRSA)
+		}
+	}
+
+	for _, tt := range []struct {
+		keyType string
+		bits    int
+	}{{"rsa-2048", 2048}, {"ecdsa-p384", 384}} {
+		cert, _ := selfSignedCert(tt.keyType)
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		var bits int
+		switch pub := leaf.PublicKey.(type) {
+		case *rsa.PublicKey:
+			bits = pub.N.BitLen()
+		case *ecdsa.PublicKey:
+			bits = pub.Curve.Params().BitSize
+		}
+		if bits != tt.bits {
+			t.Errorf("%s: key size = %d, want %d", tt.keyType, bits, tt.bits)
+		}
+	}
+}
+
+func TestSelfSignedUnknownKeyType(t *testing.T) {
+	if _, err := selfSignedCert("dsa-1024"); err == nil {
+		t.Errorf("expected error for unknown key type")
+	}
+}
+
+// newTestCert returns a certificate for cn signed by parent, or self-signed if
+// parent is nil.
+func newTestCert(t *testing.T, cn string, parent *tls.Certificate) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	issuer, signer := tmpl, crypto.Signer(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey.(crypto.Signer)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCerts(t *testing.T) {
+	ca := newTestCert(t, "test CA", nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0600); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(ClientIdentity(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, ClientCNFromContext(r.Context()))
+		})),
+	)
+	ts.TLS = &tls.Config{}
+	if err := RequireClientCerts(ts.TLS, caFile); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(cert *tls.Certificate) (string, error) {
+		c := ts.Client()
+		tr := c.Transport.(*http.Transport).Clone()
+		tr.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		c.Transport = tr
+		resp, err := c.Get(ts.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	got, err := get(newTestCert(t, "client", ca))
+	if err != nil {
+		t.Fatalf("CA-signed client certificate rejected: %v", err)
+	}
+	if got != "client" {
+		t.Errorf("client identity = %q, want %q", got, "client")
+	}
+
+	if _, err := get(newTestCert(t, "rogue", nil)); err == nil {
+		t.Errorf("self-signed client certificate accepted")
+	}
+}
+
+func TestACMEDirectory(t *testing.T) {
+	tests := []struct {
+		staging   bool
+		directory string
+		want      string
+	}{
+		{false, "", acme.LetsEncryptURL},
+		{true, "", LetsEncryptStagingURL},
+		{false, "https://ca.internal/acme/directory", "https://ca.internal/acme/directory"},
+	}
+
+	for _, tt := range tests {
+		u, err := ACMEDirectoryURL(tt.staging, tt.directory)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		dir := t.TempDir()
+		client, err := newACMEClient(dir, u)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		m, err := autocertX509(dir, client, nil)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		got := m.Client.DirectoryURL
+		if got == "" {
+			got = acme.LetsEncryptURL
+		}
+		if got != tt.want {
+			t.Errorf("DirectoryURL = %q, want %q", got, tt.want)
+		}
+	}
+
+	if _, err := ACMEDirectoryURL(true, "https://ca.internal/acme/directory"); err == nil {
+		t.Errorf("expected error for staging with directory URL")
+	}
+}
+
+func TestHostPolicy(t *testing.T) {
+	dir := t.TempDir()
+	client, err := newACMEClient(dir, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	hosts := routedHosts(map[string]http.Handler{"example.com": nil, "WWW.example.com": nil, "*": nil})
+	m, err := autocertX509(dir, client, hosts)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	tests := []struct {
+		host string
+		ok   bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"blog.example.com", false},
+		{"example.net", false},
+		{"bwsd.net", false},
+		{"*", false},
+	}
+	for _, tt := range tests {
+		err := m.HostPolicy(context.Background(), tt.host)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("%s: allowed = %v, want %v (err: %v)", tt.host, ok, tt.ok, err)
+		}
+	}
+}
+
+func TestHostPolicyFromList(t *testing.T) {
+	policy := HostPolicyFromList("example.com", " Blog.Example.com")
+	for host, ok := range map[string]bool{
+		"example.com":      true,
+		"EXAMPLE.com":      true,
+		"blog.example.com": true,
+		"www.example.com":  false,
+		"":                 false,
+	} {
+		if err := policy(context.Background(), host); (err == nil) != ok {
+			t.Errorf("%q: allowed = %v, want %v", host, err == nil, ok)
+		}
+	}
+}
+
+func TestMultiCertConfig(t *testing.T) {
+	certs, err := selfSignedCerts(DualKeyType)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("got %d certificates, want 2", len(certs))
+	}
+
+	tests := []struct {
+		name   string
+		client *tls.Config
+		want   any
+	}{
+		{"default", &tls.Config{}, &ecdsa.PublicKey{}},
+		{"rsa-only", &tls.Config{
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		}, &rsa.PublicKey{}},
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", MultiCertConfig(certs))
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+
+	for _, tt := range tests {
+		tt.client.InsecureSkipVerify = true
+		tt.client.ServerName = "example.com"
+		c, err := tls.Dial("tcp", l.Addr().String(), tt.client)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		pub := c.ConnectionState().PeerCertificates[0].PublicKey
+		if got, want := reflect.TypeOf(pub), reflect.TypeOf(tt.want); got != want {
+			t.Errorf("%s: public key type = %v, want %v", tt.name, got, want)
+		}
+		c.Close()
+	}
+}